@@ -0,0 +1,725 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
+
+// ProblemKind tells what kind of inconsistency the checker found
+type ProblemKind uint8
+
+const (
+	ProblemLost       ProblemKind = iota // clusters allocated but not used by any entry
+	ProblemCrossLink                     // cluster used by two chains
+	ProblemLoop                          // chain that loops back on itself
+	ProblemBadChain                      // chain pointing to a free, bad or invalid cluster
+	ProblemSize                          // size doesn't match the chain length
+	ProblemDot                           // wrong or missing "." and ".." entries
+	ProblemOrphanLong                    // long filename slots without a short entry
+	ProblemFATCopies                     // FAT copies differ
+	ProblemMedia                         // FAT[0] or FAT[1] don't hold the expected values
+	ProblemDirty                         // volume not cleanly unmounted or with IO errors
+	ProblemFreeCount                     // FSInfo free count doesn't match the FAT
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemLost:
+		return "lost clusters"
+	case ProblemCrossLink:
+		return "cross-linked chain"
+	case ProblemLoop:
+		return "looping chain"
+	case ProblemBadChain:
+		return "broken chain"
+	case ProblemSize:
+		return "wrong size"
+	case ProblemDot:
+		return "bad dot entry"
+	case ProblemOrphanLong:
+		return "orphaned long filename"
+	case ProblemFATCopies:
+		return "FAT copies differ"
+	case ProblemMedia:
+		return "bad media descriptor"
+	case ProblemDirty:
+		return "dirty volume"
+	case ProblemFreeCount:
+		return "wrong free count"
+	}
+	return "unknown"
+}
+
+// Problem is an inconsistency found by the checker
+type Problem struct {
+	Kind   ProblemKind
+	Path   string
+	Detail string
+	Fixed  bool
+}
+
+func (p Problem) String() string {
+	str := fmt.Sprintf("%s: %s", p.Kind, p.Detail)
+	if p.Path != "" {
+		str = fmt.Sprintf("%s: %s", p.Path, str)
+	}
+	if p.Fixed {
+		str += " (fixed)"
+	}
+	return str
+}
+
+// CheckStatus summarizes a check. The values are the usual fsck exit codes
+type CheckStatus int
+
+const (
+	CheckClean     CheckStatus = 0 // no problems
+	CheckFixed     CheckStatus = 1 // every problem was fixed
+	CheckUnfixable CheckStatus = 4 // some problems are still there
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckClean:
+		return "clean"
+	case CheckFixed:
+		return "fixed"
+	}
+	return "unfixable"
+}
+
+// CheckOptions configures Check
+type CheckOptions struct {
+	Repair bool
+	// SaveLost keeps lost chains as FOUND.000/FILE0000.CHK files instead
+	// of freeing them
+	SaveLost bool
+}
+
+// Report holds the problems found by Check
+type Report struct {
+	Problems []Problem
+}
+
+// Status tells whether the volume was clean, fixed or still has problems
+func (r Report) Status() CheckStatus {
+	status := CheckClean
+	for _, p := range r.Problems {
+		if !p.Fixed {
+			return CheckUnfixable
+		}
+		status = CheckFixed
+	}
+	return status
+}
+
+// checker keeps the state of a filesystem check. The active FAT is loaded in
+// memory and every cluster is claimed by the entry that uses it
+type checker struct {
+	v      *Volume
+	opts   CheckOptions
+	report Report
+
+	fat   []uint32 // active FAT
+	owner []int    // index+1 inside paths of the entry owning each cluster
+	paths []string
+}
+
+// Check looks for inconsistencies inside the volume and, if asked to,
+// repairs them
+func (v *Volume) Check(opts CheckOptions) (report Report, err error) {
+	if opts.Repair && v.w == nil {
+		return report, ErrReadOnly
+	}
+
+	c := &checker{v: v, opts: opts}
+
+	if c.fat, _, err = v.readFAT(v.activeFAT()); err != nil {
+		return
+	}
+	c.owner = make([]int, len(c.fat))
+
+	differ, err := c.checkCopies()
+	if err != nil {
+		return
+	}
+
+	if err = c.checkReserved(); err != nil {
+		return
+	}
+
+	if err = c.checkFreeCount(); err != nil {
+		return
+	}
+
+	if err = c.checkRoot(); err != nil {
+		return
+	}
+
+	if err = c.checkLost(); err != nil {
+		return
+	}
+
+	if opts.Repair {
+		// repairs went to every copy but the copies might have differed before
+		if differ {
+			if err = c.syncCopies(); err != nil {
+				return
+			}
+		}
+		if v.FSInfo.Valid() {
+			if err = v.RecountFree(); err != nil {
+				return
+			}
+		}
+	}
+
+	return c.report, nil
+}
+
+// problem records a problem, fix is called to repair it when repairing
+func (c *checker) problem(kind ProblemKind, path, detail string, fix func() error) error {
+	p := Problem{Kind: kind, Path: path, Detail: detail}
+
+	if c.opts.Repair && fix != nil {
+		if err := fix(); err != nil {
+			return err
+		}
+		p.Fixed = true
+	}
+
+	c.report.Problems = append(c.report.Problems, p)
+
+	return nil
+}
+
+// setFAT updates the FAT on disk and the copy kept in memory
+func (c *checker) setFAT(n, value uint32) error {
+	c.fat[n] = value
+	return c.v.setFATEntry(n, value)
+}
+
+// checkCopies compares every FAT copy with the active one
+func (c *checker) checkCopies() (differ bool, err error) {
+	active := c.v.activeFAT()
+	if !c.v.mirrored() {
+		return false, nil
+	}
+
+	_, want, err := c.v.readFAT(active)
+	if err != nil {
+		return
+	}
+
+	sector := int(c.v.Info.SectorSize)
+
+	for n := uint32(0); n < c.v.Info.FATNumber; n++ {
+		if n == active {
+			continue
+		}
+
+		_, raw, err := c.v.readFAT(n)
+		if err != nil {
+			return false, err
+		}
+
+		var sectors int
+		for i := 0; i < len(raw); i += sector {
+			if !bytes.Equal(raw[i:i+sector], want[i:i+sector]) {
+				sectors++
+			}
+		}
+
+		if sectors != 0 {
+			differ = true
+			err = c.problem(ProblemFATCopies, "",
+				fmt.Sprintf("FAT %d differs from FAT %d in %d sectors", n, active, sectors),
+				func() error { return nil }) // fixed by syncCopies at the end
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return
+}
+
+// syncCopies copies the active FAT over the other ones
+func (c *checker) syncCopies() error {
+	active := c.v.activeFAT()
+
+	_, raw, err := c.v.readFAT(active)
+	if err != nil {
+		return err
+	}
+
+	for n := uint32(0); n < c.v.Info.FATNumber; n++ {
+		if n == active {
+			continue
+		}
+		if err = c.v.writeAt(int64(c.v.Info.FATOffset)+c.v.fatCopyOffset(n), raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkReserved checks the media byte stored in FAT[0] and the end of chain
+// marker and dirty flags stored in FAT[1]
+func (c *checker) checkReserved() (err error) {
+	media := uint32(c.v.BPB.Media)
+
+	var want0, want1, clean, noErrors uint32
+	switch c.v.Info.Type {
+	case FAT12:
+		want0, want1 = 0xf00|media, 0xfff
+	case FAT16:
+		want0, want1 = 0xff00|media, 0xffff
+		clean, noErrors = 0x8000, 0x4000
+	case FAT32:
+		want0, want1 = 0xfffff00|media, 0xfffffff
+		clean, noErrors = 0x8000000, 0x4000000
+	}
+
+	if c.fat[0] != want0 {
+		err = c.problem(ProblemMedia, "",
+			fmt.Sprintf("FAT[0] is 0x%x, media byte is 0x%x", c.fat[0], media),
+			func() error { return c.setFAT(0, want0) })
+		if err != nil {
+			return
+		}
+	}
+
+	// the dirty flags are the only bits of FAT[1] allowed to be clear
+	if c.fat[1]|clean|noErrors != want1 {
+		err = c.problem(ProblemMedia, "",
+			fmt.Sprintf("FAT[1] is 0x%x, not an end of chain marker", c.fat[1]),
+			func() error { return c.setFAT(1, want1) })
+		if err != nil {
+			return
+		}
+	}
+
+	if clean != 0 && c.fat[1]&clean == 0 {
+		err = c.problem(ProblemDirty, "", "volume was not cleanly unmounted",
+			func() error { return c.setFAT(1, c.fat[1]|clean) })
+		if err != nil {
+			return
+		}
+	}
+
+	if noErrors != 0 && c.fat[1]&noErrors == 0 {
+		err = c.problem(ProblemDirty, "", "volume had disk IO errors",
+			func() error { return c.setFAT(1, c.fat[1]|noErrors) })
+	}
+
+	return
+}
+
+// checkFreeCount compares the free count of the FSInfo sector with the free
+// clusters of the FAT before anything is repaired
+func (c *checker) checkFreeCount() error {
+	count := c.v.FSInfo.FreeCount
+	if !c.v.FSInfo.Valid() || count == FSInfoUnknown {
+		return nil
+	}
+
+	var free uint32
+	for _, next := range c.fat[2:min(uint32(len(c.fat)), c.v.Info.ClusterCount+2)] {
+		if next == 0 {
+			free++
+		}
+	}
+
+	if count == free {
+		return nil
+	}
+
+	return c.problem(ProblemFreeCount, "",
+		fmt.Sprintf("free count is %d but the FAT has %d free clusters", count, free),
+		func() error { return nil }) // fixed by RecountFree at the end
+}
+
+// claim walks the chain starting at first giving its clusters to path. The
+// walk stops at the first problem, the clusters claimed until then are
+// returned together with the problem and a description of it
+func (c *checker) claim(path string, first uint32) (clusters []uint32, kind ProblemKind, detail string) {
+	c.paths = append(c.paths, path)
+	id := len(c.paths)
+
+	for cluster := first; ; {
+		switch {
+		case !c.v.validCluster(cluster):
+			return clusters, ProblemBadChain, fmt.Sprintf("chain points to invalid cluster %d", cluster)
+		case c.owner[cluster] == id:
+			return clusters, ProblemLoop, fmt.Sprintf("chain loops back to cluster %d", cluster)
+		case c.owner[cluster] != 0:
+			return clusters, ProblemCrossLink,
+				fmt.Sprintf("cluster %d is also used by %s", cluster, c.paths[c.owner[cluster]-1])
+		}
+
+		c.owner[cluster] = id
+		clusters = append(clusters, cluster)
+
+		next := c.fat[cluster]
+		switch {
+		case isEOF(c.v.Info.Type, next):
+			return clusters, 0, ""
+		case next == 0:
+			return clusters, ProblemBadChain, fmt.Sprintf("chain points to free cluster after %d", cluster)
+		case isBad(c.v.Info.Type, next):
+			return clusters, ProblemBadChain, fmt.Sprintf("chain points to a bad cluster after %d", cluster)
+		}
+
+		cluster = next
+	}
+}
+
+// truncate cuts the chain after keep clusters freeing the rest of them. The
+// last cluster kept is marked as the end of the chain
+func (c *checker) truncate(clusters []uint32, keep int) error {
+	eof, _ := mkentry(c.v.Info.Type)
+
+	if keep > 0 {
+		if err := c.setFAT(clusters[keep-1], eof); err != nil {
+			return err
+		}
+	}
+
+	for _, cluster := range clusters[keep:] {
+		c.owner[cluster] = 0
+		if err := c.setFAT(cluster, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkChain claims the clusters of e and checks its chain against the size
+// of the entry. It returns the clusters that belong to e after any repair
+func (c *checker) checkChain(p string, e *EntryInfo) (clusters []uint32, err error) {
+	if e.Location == 0 {
+		if e.Size != 0 && e.Attr&AttrDir == 0 {
+			err = c.problem(ProblemSize, p, fmt.Sprintf("size is %d but the file has no clusters", e.Size),
+				func() error {
+					e.Size = 0
+					return c.v.writeEntry(*e)
+				})
+		}
+		return
+	}
+
+	clusters, kind, detail := c.claim(p, e.Location)
+	if detail != "" {
+		err = c.problem(kind, p, detail, func() error {
+			if len(clusters) != 0 {
+				// a cut chain keeps whatever it had before the problem
+				return c.truncate(clusters, len(clusters))
+			}
+			if e.Attr&AttrDir != 0 {
+				// a directory without clusters would be the root, drop it
+				return c.v.deleteSlots(e.dir, e.slot-e.nlong, e.nlong+1)
+			}
+			e.Location, e.Size = 0, 0
+			return c.v.writeEntry(*e)
+		})
+		// sizes can only be trusted once the chain is fixed
+		if err != nil || !c.opts.Repair {
+			return
+		}
+	}
+
+	if e.Attr&AttrDir != 0 {
+		if e.Size != 0 {
+			err = c.problem(ProblemSize, p, fmt.Sprintf("directory size is %d instead of 0", e.Size),
+				func() error {
+					e.Size = 0
+					return c.v.writeEntry(*e)
+				})
+		}
+		return
+	}
+
+	cs := c.v.Info.ClusterSize
+	need := int((e.Size + cs - 1) / cs)
+
+	switch {
+	case len(clusters) < need:
+		size := uint32(len(clusters)) * cs
+		err = c.problem(ProblemSize, p,
+			fmt.Sprintf("size is %d but the chain only holds %d bytes", e.Size, size),
+			func() error {
+				e.Size = size
+				if size == 0 {
+					e.Location = 0
+				}
+				return c.v.writeEntry(*e)
+			})
+	case len(clusters) > need:
+		err = c.problem(ProblemSize, p,
+			fmt.Sprintf("size is %d but the chain has %d clusters", e.Size, len(clusters)),
+			func() error {
+				if err := c.truncate(clusters, need); err != nil {
+					return err
+				}
+				clusters = clusters[:need]
+				if need == 0 {
+					e.Location = 0
+					return c.v.writeEntry(*e)
+				}
+				return nil
+			})
+	}
+
+	return
+}
+
+// checkRoot checks the whole directory tree starting at the root
+func (c *checker) checkRoot() error {
+	if c.v.Info.Type != FAT32 {
+		d, err := c.v.openDir(0)
+		if err != nil {
+			return err
+		}
+		return c.checkDir("/", d, 0)
+	}
+
+	root := EntryInfo{Attr: AttrDir, Location: c.v.Ext32.RootCluster}
+
+	clusters, err := c.checkChain("/", &root)
+	if err != nil {
+		return err
+	}
+	if len(clusters) == 0 {
+		return c.problem(ProblemBadChain, "/", "root directory has no clusters", nil)
+	}
+
+	return c.checkDir("/", c.dirFromClusters(clusters), 0)
+}
+
+// dirFromClusters builds the layout of a directory from its clusters, the
+// disk FAT can't be used for it since it might loop
+func (c *checker) dirFromClusters(clusters []uint32) (d directory) {
+	d.cluster = clusters[0]
+	d.clusters = clusters
+	d.runSize = int64(c.v.Info.ClusterSize)
+	for _, cluster := range clusters {
+		d.runs = append(d.runs, int64(getFileOffset(cluster, c.v.BPB, c.v.Info)))
+	}
+	return
+}
+
+// checkDir checks the entries inside d, parent is the first cluster of the
+// directory holding d
+func (c *checker) checkDir(p string, d directory, parent uint32) error {
+	entries, err := c.v.scanDir(d, SlotShort|SlotDot|SlotLong)
+	if err != nil {
+		return err
+	}
+
+	isRoot := p == "/"
+	var dot, dotDot bool
+
+	for _, e := range entries {
+		entryPath := path.Join(p, e.Name())
+
+		switch e.Kind {
+		case SlotLong:
+			err = c.problem(ProblemOrphanLong, p,
+				fmt.Sprintf("%d long filename slots (%q) without a short entry", e.nlong, e.LongName),
+				func() error { return c.v.deleteSlots(e.dir, e.slot, e.nlong) })
+
+		case SlotDot:
+			err = c.checkDot(p, d, parent, &e, &dot, &dotDot)
+
+		default:
+			var clusters []uint32
+			if clusters, err = c.checkChain(entryPath, &e); err != nil {
+				return err
+			}
+			if e.Attr&AttrDir != 0 && len(clusters) != 0 {
+				err = c.checkDir(entryPath, c.dirFromClusters(clusters), d.cluster)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isRoot && (!dot || !dotDot) {
+		return c.problem(ProblemDot, p, `missing "." or ".." entry`, nil)
+	}
+
+	return nil
+}
+
+// checkDot checks a "." or ".." entry found inside d
+func (c *checker) checkDot(p string, d directory, parent uint32, e *EntryInfo, dot, dotDot *bool) error {
+	var want uint32
+	var name string
+
+	switch {
+	case p == "/" || e.slot > 1:
+		return c.problem(ProblemDot, p, fmt.Sprintf("unexpected %q entry in slot %d", e.Name(), e.slot),
+			func() error { return c.v.deleteSlots(e.dir, e.slot, 1) })
+	case e.slot == 0 && e.Name() == ".":
+		*dot, want, name = true, d.cluster, "."
+	case e.slot == 1 && e.Name() == "..":
+		*dotDot, want, name = true, c.v.dotDotCluster(parent), ".."
+		// ".." of a directory inside the root points to 0 but some
+		// systems store the FAT32 root cluster, both are fine
+		if want != parent && e.Location == parent {
+			return nil
+		}
+	default:
+		return c.problem(ProblemDot, p, fmt.Sprintf("%q entry in slot %d", e.Name(), e.slot), nil)
+	}
+
+	if e.Location == want {
+		return nil
+	}
+
+	return c.problem(ProblemDot, p,
+		fmt.Sprintf("%q points to cluster %d instead of %d", name, e.Location, want),
+		func() error {
+			e.Location = want
+			return c.v.writeEntry(*e)
+		})
+}
+
+// checkLost looks for allocated clusters that no entry claimed and groups
+// them into chains
+func (c *checker) checkLost() error {
+	last := min(uint32(len(c.fat)), c.v.Info.ClusterCount+2)
+
+	lost := func(n uint32) bool {
+		return n >= 2 && n < last && c.owner[n] == 0 && c.fat[n] != 0 && !isBad(c.v.Info.Type, c.fat[n])
+	}
+
+	// clusters pointed by another lost cluster are not the head of a chain
+	pointed := make(map[uint32]bool)
+	for n := uint32(2); n < last; n++ {
+		if lost(n) && lost(c.fat[n]) {
+			pointed[c.fat[n]] = true
+		}
+	}
+
+	var chains [][]uint32
+
+	// heads first, whatever is left over after them are loops
+	for _, heads := range []bool{true, false} {
+		for n := uint32(2); n < last; n++ {
+			if !lost(n) || (heads && pointed[n]) {
+				continue
+			}
+
+			c.paths = append(c.paths, "")
+			id := len(c.paths)
+
+			var chain []uint32
+			for cluster := n; lost(cluster); cluster = c.fat[cluster] {
+				c.owner[cluster] = id
+				chain = append(chain, cluster)
+			}
+			chains = append(chains, chain)
+		}
+	}
+
+	var found uint32
+	var saved int
+
+	for _, chain := range chains {
+		err := c.problem(ProblemLost, "",
+			fmt.Sprintf("%d clusters starting at %d", len(chain), chain[0]),
+			func() (err error) {
+				if !c.opts.SaveLost {
+					return c.truncate(chain, 0)
+				}
+
+				if found == 0 {
+					if found, err = c.foundDir(); err != nil {
+						return
+					}
+				}
+
+				if err = c.truncate(chain, len(chain)); err != nil {
+					return
+				}
+
+				err = c.saveLost(found, saved, chain)
+				saved++
+				return
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// foundDir creates the first FOUND.nnn directory that doesn't exist yet
+func (c *checker) foundDir() (uint32, error) {
+	root, err := c.v.readDir(0)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("FOUND.%03d", i)
+		if ok, _ := findFile(name, root); ok {
+			continue
+		}
+
+		entry, err := c.v.makeDir(0, name)
+		if err != nil {
+			return 0, err
+		}
+
+		// the new directory cluster is not lost
+		c.paths = append(c.paths, path.Join("/", name))
+		c.owner[entry.Location] = len(c.paths)
+		c.fat[entry.Location], _ = mkentry(c.v.Info.Type)
+
+		return entry.Location, nil
+	}
+
+	return 0, fmt.Errorf("too many FOUND directories")
+}
+
+// saveLost stores chain as the file FILEnnnn.CHK inside the directory found
+func (c *checker) saveLost(found uint32, n int, chain []uint32) error {
+	d, err := c.v.openDir(found)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("FILE%04d.CHK", n)
+
+	shortName, err := c.v.uniqueShortName(found, name, nil)
+	if err != nil {
+		return err
+	}
+
+	longEntries, _, err := c.v.nameSlots(name, shortName)
+	if err != nil {
+		return err
+	}
+
+	index, err := c.v.findFreeSlots(&d, len(longEntries)+1)
+	if err != nil {
+		return err
+	}
+
+	return c.v.addFile(d, index, EntryInfo{
+		LongName: name,
+		Attr:     AttrArchive,
+		Location: chain[0],
+		Size:     uint32(len(chain)) * c.v.Info.ClusterSize,
+		Entry:    DirEntry{Name: shortName},
+	})
+}
@@ -0,0 +1,184 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// checkKinds runs Check and returns the kinds of the problems found
+func checkKinds(t *testing.T, v *Volume, opts CheckOptions) (kinds map[ProblemKind]bool, status CheckStatus) {
+	t.Helper()
+
+	report, err := v.Check(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds = make(map[ProblemKind]bool)
+	for _, p := range report.Problems {
+		kinds[p.Kind] = true
+		if opts.Repair && !p.Fixed {
+			t.Errorf("not fixed: %v", p)
+		}
+	}
+
+	return kinds, report.Status()
+}
+
+// slotOffsetOf returns where the short entry of path is stored in the image
+func slotOffsetOf(t *testing.T, v *Volume, path string) int64 {
+	t.Helper()
+
+	e, err := v.Lookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := v.openDir(e.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d.slotOffset(e.slot)
+}
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		name   string
+		kind   ProblemKind
+		fat32  bool // needs an FSInfo sector
+		inject func(t *testing.T, v *Volume, img memImage)
+	}{
+		{"lost chain", ProblemLost, false, func(t *testing.T, v *Volume, img memImage) {
+			content := bytes.Repeat([]byte{1}, 3*int(v.Info.ClusterSize))
+			if err := v.WriteFile("LOST", bytes.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+			// the entry goes away, the chain stays allocated
+			img[slotOffsetOf(t, v, "LOST")] = NameDeleted
+		}},
+		{"cross-linked chain", ProblemCrossLink, false, func(t *testing.T, v *Volume, img memImage) {
+			for _, name := range []string{"A", "B"} {
+				if err := v.WriteFile(name, strings.NewReader(name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a, _ := v.Lookup("A")
+			b, _ := v.Lookup("B")
+			b.Location = a.Location
+			if err := v.writeEntry(b); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"bad dot entries", ProblemDot, false, func(t *testing.T, v *Volume, img memImage) {
+			if err := v.Mkdir("SUB", false); err != nil {
+				t.Fatal(err)
+			}
+			sub, _ := v.Lookup("SUB")
+			d, err := v.openDir(sub.Location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// "." and ".." point to the wrong clusters, the low word is at 26
+			binary.LittleEndian.PutUint16(img[d.slotOffset(0)+26:], uint16(sub.Location+1))
+			binary.LittleEndian.PutUint16(img[d.slotOffset(1)+26:], uint16(sub.Location))
+		}},
+		{"orphaned long name", ProblemOrphanLong, false, func(t *testing.T, v *Volume, img memImage) {
+			if err := v.WriteFile("KEEP", strings.NewReader("keep")); err != nil {
+				t.Fatal(err)
+			}
+			// long slots followed by a deleted short entry
+			deleted := shortSlot("ORPHAN     ")
+			deleted[0] = NameDeleted
+			putRaw(t, v, img, 0, 1, append(vfatSlots("an orphaned long name", "ORPHAN     "), deleted...))
+		}},
+		{"FAT copies differ", ProblemFATCopies, false, func(t *testing.T, v *Volume, img memImage) {
+			img[int64(v.Info.FATOffset)+v.fatCopyOffset(1)+100] ^= 0xff
+		}},
+		{"wrong free count", ProblemFreeCount, true, func(t *testing.T, v *Volume, img memImage) {
+			v.FSInfo.FreeCount -= 5
+			if err := v.writeFSInfo(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, format := range []struct {
+		size int64
+		opts FormatOptions
+	}{
+		{1440 << 10, FormatOptions{FATBits: 12}},
+		{16 << 20, FormatOptions{FATBits: 16}},
+		{40 << 20, FormatOptions{FATBits: 32, SectorsPerCluster: 1}},
+	} {
+		for _, c := range cases {
+			if c.fat32 && format.opts.FATBits != 32 {
+				continue
+			}
+
+			t.Run(fmt.Sprintf("FAT%d/%s", format.opts.FATBits, c.name), func(t *testing.T) {
+				v, img := newImage(t, format.size, format.opts)
+
+				if _, status := checkKinds(t, v, CheckOptions{}); status != CheckClean {
+					t.Fatalf("fresh volume is %v", status)
+				}
+
+				c.inject(t, v, img)
+
+				// without repairing nothing changes
+				before := bytes.Clone(img)
+				kinds, status := checkKinds(t, v, CheckOptions{})
+				if !kinds[c.kind] || status != CheckUnfixable {
+					t.Fatalf("found %v with status %v, want %v", kinds, status, c.kind)
+				}
+				if !bytes.Equal(before, img) {
+					t.Fatal("check changed the image")
+				}
+
+				kinds, status = checkKinds(t, v, CheckOptions{Repair: true})
+				if !kinds[c.kind] || status != CheckFixed {
+					t.Fatalf("repaired %v with status %v, want %v", kinds, status, c.kind)
+				}
+
+				if kinds, status = checkKinds(t, v, CheckOptions{}); status != CheckClean {
+					t.Fatalf("found %v after repairing", kinds)
+				}
+
+				if v.FSInfo.Valid() {
+					free, err := v.FreeClusters()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if free != v.FSInfo.FreeCount {
+						t.Errorf("free count is %d, want %d", v.FSInfo.FreeCount, free)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCheckSaveLost(t *testing.T) {
+	v, img := newImage(t, 16<<20, FormatOptions{})
+
+	content := bytes.Repeat([]byte("lost"), int(v.Info.ClusterSize))
+	if err := v.WriteFile("LOST", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	img[slotOffsetOf(t, v, "LOST")] = NameDeleted
+
+	if _, status := checkKinds(t, v, CheckOptions{Repair: true, SaveLost: true}); status != CheckFixed {
+		t.Fatalf("status %v", status)
+	}
+
+	b, err := v.ReadFile("FOUND.000/FILE0000.CHK")
+	if err != nil || !bytes.Equal(b, content) {
+		t.Fatalf("saved %d bytes, %v", len(b), err)
+	}
+
+	if kinds, status := checkKinds(t, v, CheckOptions{}); status != CheckClean {
+		t.Fatalf("found %v after repairing", kinds)
+	}
+}
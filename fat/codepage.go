@@ -0,0 +1,121 @@
+package fat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// CodePage is the OEM code page short names and volume labels are stored in.
+// The zero value is code page 437
+type CodePage struct {
+	name string
+	enc  encoding.Encoding
+}
+
+var codePages = map[string]encoding.Encoding{
+	"437": charmap.CodePage437,
+	"850": charmap.CodePage850,
+	"852": charmap.CodePage852,
+	"855": charmap.CodePage855,
+	"858": charmap.CodePage858,
+	"860": charmap.CodePage860,
+	"862": charmap.CodePage862,
+	"863": charmap.CodePage863,
+	"865": charmap.CodePage865,
+	"866": charmap.CodePage866,
+	"932": japanese.ShiftJIS,
+	"936": simplifiedchinese.GBK,
+	"949": korean.EUCKR,
+	"950": traditionalchinese.Big5,
+}
+
+// LookupCodePage returns the code page called name, either its number or
+// the number prefixed with "cp"
+func LookupCodePage(name string) (CodePage, error) {
+	number := strings.TrimPrefix(strings.ToLower(name), "cp")
+
+	enc, ok := codePages[number]
+	if !ok {
+		return CodePage{}, fmt.Errorf("unknown code page %q, supported ones are %s", name, strings.Join(CodePages(), ", "))
+	}
+
+	return CodePage{name: number, enc: enc}, nil
+}
+
+// CodePages returns the numbers of the supported code pages
+func CodePages() []string {
+	names := make([]string, 0, len(codePages))
+	for name := range codePages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the name of the code page as "cpNNN"
+func (cp CodePage) String() string {
+	if cp.name == "" {
+		return "cp437"
+	}
+	return "cp" + cp.name
+}
+
+func (cp CodePage) encoding() encoding.Encoding {
+	if cp.enc == nil {
+		return charmap.CodePage437
+	}
+	return cp.enc
+}
+
+// decode converts b from the code page into UTF-8, bytes that don't map
+// to anything become U+FFFD
+func (cp CodePage) decode(b []byte) string {
+	s, err := cp.encoding().NewDecoder().Bytes(b)
+	if err != nil {
+		return strings.ToValidUTF8(string(b), string(utf8.RuneError))
+	}
+	return string(s)
+}
+
+// encodeRune returns r in the code page, ok is false if it can't be
+// represented there
+func (cp CodePage) encodeRune(r rune) (b []byte, ok bool) {
+	if c, isCharmap := cp.encoding().(*charmap.Charmap); isCharmap {
+		c, ok := c.EncodeRune(r)
+		return []byte{c}, ok
+	}
+
+	b, err := cp.encoding().NewEncoder().Bytes([]byte(string(r)))
+	return b, err == nil
+}
+
+// encode converts s into the code page, every character must be one
+// encodeRune accepts
+func (cp CodePage) encode(s string) (b []byte) {
+	for _, r := range s {
+		c, _ := cp.encodeRune(r)
+		b = append(b, c...)
+	}
+	return
+}
+
+// cut returns the longest prefix of s that takes at most n bytes once encoded,
+// a double byte character is never split
+func (cp CodePage) cut(s string, n int) string {
+	for i, r := range s {
+		c, _ := cp.encodeRune(r)
+		if n -= len(c); n < 0 {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package fat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodePageRoundTrip(t *testing.T) {
+	cases := []struct {
+		cp    string
+		name  string
+		short string // "" if name is stored as a short name as it is
+		first byte   // first byte of the short name on disk
+	}{
+		{"437", "ÉCOLE.TXT", "", 0x90},
+		{"437", "ÄÖÜ.DAT", "", 0x8e},
+		{"437", "ÕTRA.TXT", "_TRA~1.TXT", '_'}, // not in CP437
+		{"850", "ÕTRA.TXT", "", NameKanji},     // 0xe5 is escaped
+		{"850", "ÀÉÎ.TXT", "", 0xb7},
+		{"866", "ЖУРНАЛ.LOG", "", 0x86},
+	}
+
+	for _, c := range cases {
+		cp, err := LookupCodePage(c.cp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, img := newImage(t, 1440<<10, FormatOptions{})
+		v.CodePage = cp
+
+		if err = v.WriteFile(c.name, strings.NewReader(c.name)); err != nil {
+			t.Fatalf("cp%s %q: %v", c.cp, c.name, err)
+		}
+
+		e, err := v.Lookup(c.name)
+		if err != nil {
+			t.Fatalf("cp%s %q: %v", c.cp, c.name, err)
+		}
+
+		short, long := c.short, c.name
+		if short == "" {
+			short, long = c.name, ""
+		}
+		if e.ShortName != short || e.LongName != long {
+			t.Errorf("cp%s %q: short name %q long name %q", c.cp, c.name, e.ShortName, e.LongName)
+		}
+		if long == "" && e.nlong != 0 {
+			t.Errorf("cp%s %q: %d long slots", c.cp, c.name, e.nlong)
+		}
+
+		d, err := v.openDir(e.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img[d.slotOffset(e.slot)]; b != c.first {
+			t.Errorf("cp%s %q: stored as %#x, want %#x", c.cp, c.name, b, c.first)
+		}
+
+		// another code page reads other characters from the same bytes
+		if c.cp != "437" && c.short == "" {
+			v.CodePage = CodePage{}
+			if e, err = v.Lookup(c.name); err != ErrNotFound {
+				t.Errorf("cp%s %q: found as %q with cp437", c.cp, c.name, e.ShortName)
+			}
+		}
+	}
+}
+
+func TestCodePageLabel(t *testing.T) {
+	cp, err := LookupCodePage("cp850")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, img := newImage(t, 1440<<10, FormatOptions{Label: "ÕTRO DISCO", CodePage: cp})
+	v.CodePage = cp
+
+	if label, err := v.Label(); err != nil || label != "ÕTRO DISCO" {
+		t.Fatalf("label %q, %v", label, err)
+	}
+
+	// the entry in the root directory escapes 0xe5 too
+	if b := img[v.Info.RootDirOffset]; b != NameKanji {
+		t.Fatalf("label stored as %#x in the root directory", b)
+	}
+}
@@ -0,0 +1,729 @@
+package fat
+
+import (
+	"cmp"
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Root returns the entries inside the root directory
+func (v *Volume) Root() ([]EntryInfo, error) {
+	return v.readDir(0)
+}
+
+// List returns the entries inside the directory at path. If path points to a
+// file the result only holds that file
+func (v *Volume) List(path string) (entries []EntryInfo, err error) {
+	return v.list(splitPath(path))
+}
+
+func (v *Volume) list(splited []string) (entries []EntryInfo, err error) {
+	if entries, err = v.Root(); err != nil {
+		return
+	}
+
+	for _, p := range splited {
+		if entries, err = v.walk(entries, p); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Lookup returns the entry at path
+func (v *Volume) Lookup(path string) (entry EntryInfo, err error) {
+	return v.lookup(splitPath(path))
+}
+
+func (v *Volume) lookup(splited []string) (entry EntryInfo, err error) {
+	if len(splited) == 0 {
+		return EntryInfo{}, ErrNotFound
+	}
+
+	entries, err := v.list(splited[:len(splited)-1])
+	if err != nil {
+		return
+	}
+
+	ok, entry := findFile(splited[len(splited)-1], entries)
+	if !ok {
+		return EntryInfo{}, ErrNotFound
+	}
+
+	return entry, nil
+}
+
+// lookupDir returns the first cluster of the directory at splited
+func (v *Volume) lookupDir(splited []string) (cluster uint32, err error) {
+	if len(splited) == 0 {
+		return 0, nil
+	}
+
+	entry, err := v.lookup(splited)
+	if err != nil {
+		return
+	}
+
+	if entry.Attr&AttrDir == 0 {
+		return 0, ErrNotDir
+	}
+
+	return entry.Location, nil
+}
+
+// Name returns the long filename of the entry or, if it has none, its short
+// name
+func (e EntryInfo) Name() string {
+	if e.LongName != "" {
+		return e.LongName
+	}
+	return e.ShortName
+}
+
+// findFile looks for name inside entries. FAT names are case insensitive so
+// the long name and the short name are compared ignoring case. The short
+// name can also be given padded as it's stored on disk
+func findFile(name string, entries []EntryInfo) (ok bool, file EntryInfo) {
+	for _, v := range entries {
+		if name == v.LongName || name == string(v.Entry.Name[:]) ||
+			strings.EqualFold(name, v.LongName) ||
+			strings.EqualFold(name, v.ShortName) {
+			file = v
+			break
+		}
+	}
+	return file != (EntryInfo{}), file
+}
+
+// uniqueShortName returns the short name for name inside the directory
+// starting at cluster. A numeric tail is added if the basis name isn't name
+// itself or if another entry already uses it. except is left out of the
+// check, it's the entry being renamed if there's one
+func (v *Volume) uniqueShortName(cluster uint32, name string, except *EntryInfo) (short Str11Byte, err error) {
+	primary, ext, exact, err := basisName(name, v.CodePage)
+	if err != nil {
+		return
+	}
+
+	entries, err := v.readDirSlots(cluster, SlotShort|SlotVolume)
+	if err != nil {
+		return
+	}
+
+	others := entries[:0]
+	for _, e := range entries {
+		if except == nil || !sameEntry(e, *except) {
+			others = append(others, e)
+		}
+	}
+
+	free := func(primary string) bool {
+		ok, _ := findFile(joinShort(primary, ext), others)
+		return !ok
+	}
+
+	if exact && free(primary) {
+		return packShort(primary, ext, v.CodePage), nil
+	}
+
+	// ~1 to ~4 go first, then the hashed tails and if all of them are
+	// taken the rest of the numbers
+	for n := 1; n <= 4; n++ {
+		if c := withTail(primary, n, v.CodePage); free(c) {
+			return packShort(c, ext, v.CodePage), nil
+		}
+	}
+	for n := 1; n <= 9; n++ {
+		if c := withHashTail(primary, name, n, v.CodePage); free(c) {
+			return packShort(c, ext, v.CodePage), nil
+		}
+	}
+	for n := 5; n <= 999999; n++ {
+		if c := withTail(primary, n, v.CodePage); free(c) {
+			return packShort(c, ext, v.CodePage), nil
+		}
+	}
+
+	return short, ErrDirFull
+}
+
+func (v *Volume) walk(src []EntryInfo, dst string) (content []EntryInfo, err error) {
+	ok, entry := findFile(dst, src)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if entry.Attr&AttrDir == 0 {
+		return []EntryInfo{entry}, nil
+	}
+
+	return v.readDir(entry.Location)
+}
+
+// directory tells where the slots of a directory are stored. FAT12/16 keep
+// the root directory in a fixed region right after the FATs, every other
+// directory (FAT32 root included) is a regular cluster chain
+type directory struct {
+	cluster  uint32   // first cluster, 0 for the FAT12/16 root
+	clusters []uint32 // cluster chain, empty for the FAT12/16 root
+	runs     []int64  // offset of every cluster (or of the fixed root region)
+	runSize  int64    // bytes inside every run
+	end      int      // slot of the end marker seen by findFreeSlots, slots() if it didn't see one
+}
+
+// slotOffset returns where the slot number index is stored
+func (d directory) slotOffset(index int) int64 {
+	perRun := int(d.runSize / RootEntrySize)
+	return d.runs[index/perRun] + int64(index%perRun)*RootEntrySize
+}
+
+// slots returns the number of slots the directory can hold right now
+func (d directory) slots() int {
+	return len(d.runs) * int(d.runSize/RootEntrySize)
+}
+
+// openDir returns the layout of the directory starting at cluster. Cluster 0
+// is the root directory, same as it's stored in ".." entries
+func (v *Volume) openDir(cluster uint32) (d directory, err error) {
+	if cluster == 0 {
+		if v.Info.Type != FAT32 {
+			d.runs = []int64{int64(v.Info.RootDirOffset)}
+			d.runSize = int64(v.BPB.RootEntryCount) * RootEntrySize
+			return d, nil
+		}
+		cluster = v.Ext32.RootCluster
+	}
+
+	clusters, err := v.chain(cluster)
+	if err != nil {
+		return
+	}
+
+	d.cluster = cluster
+	d.clusters = clusters
+	d.runSize = int64(v.Info.ClusterSize)
+	for _, c := range clusters {
+		d.runs = append(d.runs, int64(getFileOffset(c, v.BPB, v.Info)))
+	}
+
+	return d, nil
+}
+
+// readDir reads the live entries of the directory starting at cluster
+func (v *Volume) readDir(cluster uint32) (entries []EntryInfo, err error) {
+	return v.readDirSlots(cluster, SlotLive)
+}
+
+// ListSlots is like List but the result holds every slot whose kind is set in
+// kinds. Long filename parts are attached to the short entry they belong to,
+// parts that don't belong to any are returned as SlotLong entries
+func (v *Volume) ListSlots(path string, kinds SlotKind) (entries []EntryInfo, err error) {
+	var cluster uint32
+
+	if len(splitPath(path)) != 0 {
+		entry, err := v.Lookup(path)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Attr&AttrDir == 0 {
+			return []EntryInfo{entry}, nil
+		}
+		cluster = entry.Location
+	}
+
+	return v.readDirSlots(cluster, kinds)
+}
+
+// readDirSlots reads the directory starting at cluster following its cluster
+// chain and returns the entries whose kind is in kinds
+func (v *Volume) readDirSlots(cluster uint32, kinds SlotKind) (entries []EntryInfo, err error) {
+	d, err := v.openDir(cluster)
+	if err != nil {
+		return
+	}
+
+	return v.scanDir(d, kinds)
+}
+
+// scanDir decodes the slots of d and returns the entries whose kind is in kinds
+func (v *Volume) scanDir(d directory, kinds SlotKind) (entries []EntryInfo, err error) {
+	var seq longNameSeq
+	var ended bool
+
+	// orphan drops the long filename collected so far, it's only returned
+	// if the caller asked for long entries
+	orphan := func() {
+		if len(seq.parts) != 0 && kinds&SlotLong != 0 {
+			entries = append(entries, EntryInfo{
+				LongName: buildLongFilename(seq.parts),
+				Attr:     AttrLongName,
+				Kind:     SlotLong,
+				dir:      d.cluster,
+				slot:     seq.first,
+				nlong:    len(seq.parts),
+			})
+		}
+		seq = longNameSeq{}
+	}
+
+	buf := make([]byte, d.runSize)
+	index := 0
+
+OUT:
+	for _, run := range d.runs {
+		if err = v.readAt(run, buf); err != nil {
+			return
+		}
+
+		for slot := buf; len(slot) >= RootEntrySize; slot, index = slot[RootEntrySize:], index+1 {
+			kind := classifySlot(slot)
+
+			if ended {
+				kind = SlotFree
+			}
+
+			switch kind {
+			case SlotEnd, SlotFree:
+				orphan()
+
+				// nothing is stored past the end marker unless the
+				// caller wants to look at the free slots
+				ended = true
+				if kinds&SlotFree == 0 {
+					break OUT
+				}
+				continue
+
+			case SlotLong: // long filename
+				var entry DirEntryLong
+
+				if _, err = binary.Decode(slot, binary.LittleEndian, &entry); err != nil {
+					return
+				}
+
+				if !seq.add(entry, index) {
+					// the part doesn't continue the sequence we were
+					// building so that one is dropped, the part itself
+					// might still start a new one
+					orphan()
+					if !seq.add(entry, index) {
+						seq = longNameSeq{parts: [][]uint16{longNameParts(entry)}, first: index}
+						orphan()
+					}
+				}
+
+				continue
+			}
+
+			var short DirEntry
+			if _, err = binary.Decode(slot, binary.LittleEndian, &short); err != nil {
+				return
+			}
+
+			entryInfo := EntryInfo{
+				ShortName: v.shortName(short.Name, short.NTRes, kind),
+				Attr:      short.Attr,
+				Location:  uint32(short.FirstClusterHI)<<16 + uint32(short.FirstClusterLO),
+				Size:      short.FileSize,
+				Crt:       fatTimeToTime(short.CDate, short.CTime, short.CTTenth, v.location()),
+				Mod:       fatTimeToTime(short.WDate, short.WTime, 0, v.location()),
+				Acc:       fatTimeToTime(short.LDate, 0, 0, v.location()),
+				Entry:     short,
+				Kind:      kind,
+				dir:       d.cluster,
+				slot:      index,
+			}
+
+			// attach the long filename only if it's complete and it was
+			// made for this short name
+			if len(seq.parts) != 0 {
+				if kind == SlotShort && seq.belongsTo(short.Name) {
+					entryInfo.LongName = buildLongFilename(seq.parts)
+					entryInfo.nlong = len(seq.parts)
+					seq = longNameSeq{}
+				} else {
+					orphan()
+				}
+			}
+
+			// deleted long filename parts are not entries
+			if kind == SlotDeleted && short.Attr&0x3f == AttrLongName {
+				continue
+			}
+
+			if kinds&kind != 0 {
+				entries = append(entries, entryInfo)
+			}
+		}
+	}
+
+	orphan()
+
+	return
+}
+
+// Label returns the volume label stored in the root directory. The label in
+// the boot sector is only a copy and it's not used
+func (v *Volume) Label() (string, error) {
+	entries, err := v.readDirSlots(0, SlotVolume)
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+
+	return entries[0].ShortName, nil
+}
+
+// splitPath split the path and returns a slice with all the names
+func splitPath(path string) (elements []string) {
+	var dir, file string
+	dir = path
+
+	for {
+		dir = filepath.Clean(dir)
+		dir, file = filepath.Split(dir)
+
+		if file == "" || file == "." {
+			break
+		}
+
+		elements = append(elements, file)
+
+		if dir == "" {
+			break
+		}
+	}
+	slices.Reverse(elements)
+	return
+}
+
+// buildLongFilename joins the parts of a long filename, given in the order
+// they are stored on disk, and decodes them from UTF-16. The name ends at the
+// first 0x0000, anything after it is 0xffff padding
+func buildLongFilename(src [][]uint16) string {
+	var units []uint16
+
+	for i := len(src) - 1; i >= 0; i-- {
+		units = append(units, src[i]...)
+	}
+
+	if end := slices.Index(units, 0x0000); end >= 0 {
+		units = units[:end]
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// scanFreeSlots reads d looking for the first run of n free slots, index is
+// -1 if there's none. trailing counts the free slots d ends with and end is
+// the slot of the end marker, d.slots() if there's none. Slots after the end
+// marker are free whatever they hold
+func (v *Volume) scanFreeSlots(d directory, n int) (index, trailing, end int, err error) {
+	buf := make([]byte, d.runSize)
+	perRun := int(d.runSize / RootEntrySize)
+	index, end = -1, d.slots()
+
+	for r, run := range d.runs {
+		if err = v.readAt(run, buf); err != nil {
+			return
+		}
+
+		for i := range perRun {
+			slot := r*perRun + i
+
+			switch kind := classifySlot(buf[i*RootEntrySize:]); {
+			case slot > end, kind == SlotDeleted:
+				trailing++
+			case kind == SlotEnd:
+				end = slot
+				trailing++
+			default:
+				trailing = 0
+			}
+
+			if trailing == n && index < 0 {
+				index = slot - n + 1
+			}
+		}
+	}
+
+	return
+}
+
+// findFreeSlots returns the index of the first run of n free slots inside d.
+// If there's no room left the directory grows with new zeroed clusters, the
+// fixed root directory of FAT12/16 cannot grow
+func (v *Volume) findFreeSlots(d *directory, n int) (index int, err error) {
+	index, free, end, err := v.scanFreeSlots(*d, n)
+	if err != nil {
+		return
+	}
+
+	d.end = end
+	if index >= 0 {
+		return index, nil
+	}
+
+	if d.cluster == 0 && v.Info.Type != FAT32 {
+		return 0, ErrDirFull
+	}
+
+	// the free slots at the end of the directory are used together with
+	// the new clusters
+	index = d.slots() - free
+
+	for d.slots()-index < n {
+		last := d.clusters[len(d.clusters)-1]
+
+		cluster, err := v.allocCluster(last)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = v.zeroCluster(cluster); err != nil {
+			return 0, err
+		}
+
+		d.clusters = append(d.clusters, cluster)
+		d.runs = append(d.runs, int64(getFileOffset(cluster, v.BPB, v.Info)))
+	}
+
+	return index, v.writeFSInfo()
+}
+
+// addFile stores fileEntry and its long filename inside d starting at the
+// slot number index. findFreeSlots must have checked there's room for it
+func (v *Volume) addFile(d directory, index int, fileEntry EntryInfo) (err error) {
+	var entry DirEntry
+
+	// copy short name to dir entry
+	entry.Attr = fileEntry.Attr
+	entry.Name = fileEntry.Entry.Name
+
+	// timestamps not given are set to now
+	now := time.Now()
+	entry.CDate, entry.CTime, entry.CTTenth = timeToFatTime(cmp.Or(fileEntry.Crt, now), v.location())
+	entry.WDate, entry.WTime, _ = timeToFatTime(cmp.Or(fileEntry.Mod, now), v.location())
+	entry.LDate, _, _ = timeToFatTime(cmp.Or(fileEntry.Acc, now), v.location())
+
+	// file size and first cluster
+	entry.FileSize = fileEntry.Size
+	entry.FirstClusterHI = uint16(fileEntry.Location >> 16)
+	entry.FirstClusterLO = uint16(fileEntry.Location)
+
+	longEntries, ntres, err := v.nameSlots(fileEntry.LongName, entry.Name)
+	if err != nil {
+		return err
+	}
+	entry.NTRes = ntres
+
+	return v.putSlots(d, index, longEntries, entry)
+}
+
+// nameSlots returns the long entries that store name before its short name
+// short. A name that only differs from short in the case of its base name or
+// extension needs none, the NTRes flags returned are enough
+func (v *Volume) nameSlots(name string, short Str11Byte) (longEntries []DirEntryLong, ntres uint8, err error) {
+	if flags, ok := caseFlags(name, short, v.CodePage); ok {
+		return nil, flags, nil
+	}
+
+	longEntries, err = convNameLong(name, string(short[:]))
+
+	return
+}
+
+// putSlots writes the long entries followed by the short entry inside d
+// starting at the slot number index
+func (v *Volume) putSlots(d directory, index int, longEntries []DirEntryLong, entry DirEntry) (err error) {
+	// long entries might be split between two clusters
+	for _, e := range longEntries {
+		if err = v.writeAt(d.slotOffset(index), e); err != nil {
+			return err
+		}
+		index++
+	}
+
+	if err = v.writeAt(d.slotOffset(index), entry); err != nil {
+		return err
+	}
+
+	// slots past the end marker can hold anything, if they were used the
+	// directory ends again right after them
+	if index >= d.end && index+1 < d.slots() {
+		return v.writeAt(d.slotOffset(index+1), uint8(NameEnd))
+	}
+
+	return nil
+}
+
+// writeEntry stores the short entry of e back into its slot
+func (v *Volume) writeEntry(e EntryInfo) error {
+	d, err := v.openDir(e.dir)
+	if err != nil {
+		return err
+	}
+
+	e.Entry.Attr = e.Attr
+	e.Entry.FileSize = e.Size
+	e.Entry.FirstClusterHI = uint16(e.Location >> 16)
+	e.Entry.FirstClusterLO = uint16(e.Location)
+
+	return v.writeAt(d.slotOffset(e.slot), e.Entry)
+}
+
+// deleteSlots marks count slots of the directory starting at cluster as
+// deleted beginning with the slot number index
+func (v *Volume) deleteSlots(cluster uint32, index, count int) error {
+	d, err := v.openDir(cluster)
+	if err != nil {
+		return err
+	}
+
+	for i := index; i < index+count; i++ {
+		if err = v.writeAt(d.slotOffset(i), uint8(NameDeleted)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dotDotCluster returns the cluster the ".." entry of a directory inside
+// parent points to. It's 0 when the parent is the root directory, even on
+// FAT32
+func (v *Volume) dotDotCluster(parent uint32) uint32 {
+	if parent == v.Ext32.RootCluster && v.Info.Type == FAT32 {
+		return 0
+	}
+	return parent
+}
+
+// makeDir creates the directory name inside the directory starting at
+// parent. The new directory gets a zeroed cluster with its "." and ".."
+// entries
+func (v *Volume) makeDir(parent uint32, name string) (entry EntryInfo, err error) {
+	d, err := v.openDir(parent)
+	if err != nil {
+		return
+	}
+
+	shortName, err := v.uniqueShortName(parent, name, nil)
+	if err != nil {
+		return
+	}
+
+	longEntries, ntres, err := v.nameSlots(name, shortName)
+	if err != nil {
+		return
+	}
+
+	index, err := v.findFreeSlots(&d, len(longEntries)+1)
+	if err != nil {
+		return
+	}
+
+	cluster, err := v.allocCluster(0)
+	if err != nil {
+		return
+	}
+
+	if err = v.zeroCluster(cluster); err != nil {
+		v.freeChain(cluster)
+		return
+	}
+
+	if err = v.writeFSInfo(); err != nil {
+		v.freeChain(cluster)
+		return
+	}
+
+	var dot, dotDot DirEntry
+	dot.Name, dotDot.Name = dotName, dotDotName
+	dot.Attr, dotDot.Attr = AttrDir, AttrDir
+	dot.CDate, dot.CTime, dot.CTTenth = timeToFatTime(time.Now(), v.location())
+	dot.WDate, dot.WTime, dot.LDate = dot.CDate, dot.CTime, dot.CDate
+	dotDot.CDate, dotDot.CTime, dotDot.CTTenth = dot.CDate, dot.CTime, dot.CTTenth
+	dotDot.WDate, dotDot.WTime, dotDot.LDate = dot.WDate, dot.WTime, dot.LDate
+	dot.FirstClusterHI, dot.FirstClusterLO = uint16(cluster>>16), uint16(cluster)
+	up := v.dotDotCluster(parent)
+	dotDot.FirstClusterHI, dotDot.FirstClusterLO = uint16(up>>16), uint16(up)
+
+	offset := int64(getFileOffset(cluster, v.BPB, v.Info))
+	if err = v.writeAt(offset, [2]DirEntry{dot, dotDot}); err != nil {
+		v.freeChain(cluster)
+		return
+	}
+
+	entry = EntryInfo{
+		ShortName: v.shortName(shortName, ntres, SlotShort),
+		LongName:  name,
+		Attr:      AttrDir,
+		Location:  cluster,
+		Entry:     DirEntry{Name: shortName, NTRes: ntres},
+		dir:       d.cluster,
+		slot:      index + len(longEntries),
+		nlong:     len(longEntries),
+	}
+
+	if err = v.addFile(d, index, entry); err != nil {
+		v.freeChain(cluster)
+	}
+
+	return
+}
+
+// Mkdir creates the directory at path. With parents set the missing
+// directories along path are created too and an existing directory isn't
+// an error
+func (v *Volume) Mkdir(path string, parents bool) error {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	splited := splitPath(path)
+	if len(splited) == 0 {
+		// that's the root directory
+		if parents {
+			return nil
+		}
+		return ErrExists
+	}
+
+	var cluster uint32
+
+	for i, name := range splited {
+		entries, err := v.readDir(cluster)
+		if err != nil {
+			return err
+		}
+
+		ok, entry := findFile(name, entries)
+		last := i == len(splited)-1
+
+		switch {
+		case ok && entry.Attr&AttrDir == 0 && last:
+			return ErrExists
+		case ok && entry.Attr&AttrDir == 0:
+			return ErrNotDir
+		case ok && last && !parents:
+			return ErrExists
+		case ok:
+			cluster = entry.Location
+			continue
+		case !last && !parents:
+			return ErrNotFound
+		}
+
+		if entry, err = v.makeDir(cluster, name); err != nil {
+			return err
+		}
+		cluster = entry.Location
+	}
+
+	return nil
+}
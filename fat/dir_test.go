@@ -0,0 +1,312 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// names of the live entries inside the directory at path
+func listNames(t *testing.T, v *Volume, path string) (names []string) {
+	t.Helper()
+
+	entries, err := v.List(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Kind == SlotShort {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+
+	return
+}
+
+func TestWriteAfterEndMarker(t *testing.T) {
+	v, img := newImage(t, 16<<20, FormatOptions{})
+
+	if err := v.Mkdir("SUB", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// SUB comes before A inside the root directory so it stays listed
+	for _, c := range []struct {
+		dir  string
+		keep []string
+	}{{"", []string{"SUB"}}, {"SUB/", nil}} {
+		dir := c.dir
+
+		for _, name := range []string{"A", "B"} {
+			if err := v.WriteFile(dir+name, strings.NewReader(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the end marker moves onto A, B is left behind it
+		a, err := v.Lookup(dir + "A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := v.openDir(a.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img[d.slotOffset(a.slot)] = NameEnd
+
+		if got := listNames(t, v, dir); !slices.Equal(got, c.keep) {
+			t.Fatalf("%q: listed %q after the end marker", dir, got)
+		}
+
+		// C takes the slot of A and must not bring B back
+		if err := v.WriteFile(dir+"C", strings.NewReader("C")); err != nil {
+			t.Fatal(err)
+		}
+		got := listNames(t, v, dir)
+		if want := append([]string{"C"}, c.keep...); !slices.Equal(got, want) {
+			t.Fatalf("%q: listed %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestWriteBeforeEndMarker(t *testing.T) {
+	v, _ := newImage(t, 16<<20, FormatOptions{})
+
+	for _, name := range []string{"A", "B", "C"} {
+		if err := v.WriteFile(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the slot freed by B is reused and C stays where it is
+	if err := v.Remove("B", RemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.WriteFile("D", strings.NewReader("D")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listNames(t, v, ""); !slices.Equal(got, []string{"A", "C", "D"}) {
+		t.Fatalf("listed %q, want [A C D]", got)
+	}
+}
+
+// shortSlot returns a raw short entry for a regular empty file called name
+func shortSlot(name string) []byte {
+	slot := make([]byte, RootEntrySize)
+	copy(slot, name)
+	slot[11] = AttrArchive
+	return slot
+}
+
+// putRaw stores slots inside the directory starting at cluster from the slot
+// number index on
+func putRaw(t *testing.T, v *Volume, img memImage, cluster uint32, index int, slots []byte) {
+	t.Helper()
+
+	d, err := v.openDir(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(slots); i += RootEntrySize {
+		copy(img[d.slotOffset(index+i/RootEntrySize):], slots[i:i+RootEntrySize])
+	}
+}
+
+// slot returns the slot number i of slots
+func slot(slots []byte, i int) []byte {
+	return slots[i*RootEntrySize : (i+1)*RootEntrySize]
+}
+
+func TestLongNameSequence(t *testing.T) {
+	const (
+		name  = "a name that takes three slots.txt"
+		short = "ANAMET~1TXT"
+		other = "another long name for a second file"
+	)
+
+	good := vfatSlots(name, short)
+	if len(good) != 3*RootEntrySize {
+		t.Fatalf("%d long slots, want 3", len(good)/RootEntrySize)
+	}
+	second := vfatSlots(other, "ANOTHE~1   ")
+
+	deleted := shortSlot("DELETED    ")
+	deleted[0] = NameDeleted
+
+	badChecksum := bytes.Clone(good)
+	badChecksum[RootEntrySize+13]++
+
+	cases := []struct {
+		name  string
+		slots [][]byte
+		valid bool
+	}{
+		{"complete", [][]byte{good}, true},
+		{"wrong checksum", [][]byte{badChecksum}, false},
+		{"missing ordinal", [][]byte{slot(good, 0), slot(good, 2)}, false},
+		{"missing last part", [][]byte{slot(good, 1), slot(good, 2)}, false},
+		{"interleaved", [][]byte{slot(good, 0), slot(second, 0), slot(good, 1), slot(second, 1), slot(good, 2)}, false},
+		{"deleted slot between parts", [][]byte{slot(good, 0), slot(good, 1), deleted, slot(good, 2)}, false},
+	}
+
+	for _, c := range cases {
+		v, img := newImage(t, 1440<<10, FormatOptions{})
+
+		slots := append(bytes.Join(c.slots, nil), shortSlot(short)...)
+		putRaw(t, v, img, 0, 0, slots)
+
+		checkLongName(t, v, "", c.name, name, c.valid)
+	}
+}
+
+// checkLongName looks for the file ANAMET~1.TXT inside dir, valid tells if
+// it must have got name as its long name or if its long parts are orphans
+func checkLongName(t *testing.T, v *Volume, dir, what, name string, valid bool) {
+	t.Helper()
+
+	entries, err := v.ListSlots(dir, SlotShort|SlotLong)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	var orphans int
+	for _, e := range entries {
+		switch {
+		case e.Kind == SlotLong:
+			orphans++
+		case e.ShortName == "ANAMET~1.TXT":
+			found = true
+			if valid && e.LongName != name {
+				t.Errorf("%s: long name %q, want %q", what, e.LongName, name)
+			}
+			if !valid && e.LongName != "" {
+				t.Errorf("%s: long name %q attached", what, e.LongName)
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("%s: short entry not listed", what)
+	}
+	if valid && orphans != 0 {
+		t.Errorf("%s: %d orphans", what, orphans)
+	}
+	if !valid && orphans == 0 {
+		t.Errorf("%s: no orphans", what)
+	}
+}
+
+func TestLongNameAcrossClusters(t *testing.T) {
+	const (
+		name  = "a name that takes three slots.txt"
+		short = "ANAMET~1TXT"
+	)
+
+	for _, valid := range []bool{true, false} {
+		// 16 slots per cluster
+		v, img := newImage(t, 16<<20, FormatOptions{FATBits: 16, SectorsPerCluster: 1})
+
+		if err := v.Mkdir("SUB", false); err != nil {
+			t.Fatal(err)
+		}
+		// the second cluster of SUB doesn't follow the first one
+		if err := v.WriteFile("GAP", strings.NewReader("gap")); err != nil {
+			t.Fatal(err)
+		}
+		for i := range 20 {
+			if err := v.WriteFile(fmt.Sprintf("SUB/F%d", i), strings.NewReader("")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sub, err := v.Lookup("SUB")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := v.openDir(sub.Location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.clusters) < 2 || d.clusters[1] == d.clusters[0]+1 || d.runSize/RootEntrySize != 16 {
+			t.Fatalf("clusters %v of %d slots", d.clusters, d.runSize/RootEntrySize)
+		}
+
+		// the last part sits at the end of the first cluster, the rest and
+		// the short entry at the start of the second one
+		slots := vfatSlots(name, short)
+		if !valid {
+			slots[2*RootEntrySize+13]++
+		}
+		putRaw(t, v, img, sub.Location, 15, append(slots, shortSlot(short)...))
+
+		checkLongName(t, v, "SUB", "across clusters", name, valid)
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, img := newImage(t, format.size, format.opts)
+
+			// garbage in every free cluster
+			for n := uint32(2); n < v.Info.ClusterCount+2; n++ {
+				if next, err := v.FATEntry(n); err != nil || next != 0 {
+					continue
+				}
+				offset := int64(getFileOffset(n, v.BPB, v.Info))
+				copy(img[offset:], bytes.Repeat([]byte{0x5a}, int(v.Info.ClusterSize)))
+			}
+
+			if err := v.Mkdir("a/b", true); err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := v.Lookup("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := v.Lookup("a/b")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// ".." of a directory inside the root points to 0, even on FAT32.
+			// a holds the entry of b after the dot entries
+			for _, c := range []struct {
+				dir    EntryInfo
+				parent uint32
+				used   int
+			}{{a, 0, 3}, {b, a.Location, 2}} {
+				offset := int64(getFileOffset(c.dir.Location, v.BPB, v.Info))
+				cluster := img[offset : offset+int64(v.Info.ClusterSize)]
+
+				for i, want := range []struct {
+					name     string
+					location uint32
+				}{{".          ", c.dir.Location}, {"..         ", c.parent}} {
+					slot := cluster[i*RootEntrySize:]
+					location := uint32(binary.LittleEndian.Uint16(slot[20:]))<<16 | uint32(binary.LittleEndian.Uint16(slot[26:]))
+					if string(slot[:11]) != want.name || slot[11] != AttrDir || location != want.location {
+						t.Errorf("%s: slot %d is %q attr %#x cluster %d, want %q cluster %d",
+							c.dir.Name(), i, slot[:11], slot[11], location, want.name, want.location)
+					}
+				}
+
+				if rest := cluster[c.used*RootEntrySize:]; !bytes.Equal(rest, make([]byte, len(rest))) {
+					t.Errorf("%s: cluster not zeroed", c.dir.Name())
+				}
+			}
+
+			report, err := v.Check(CheckOptions{})
+			if err != nil || report.Status() != CheckClean {
+				t.Fatalf("%v: %v", report.Problems, err)
+			}
+		})
+	}
+}
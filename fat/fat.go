@@ -0,0 +1,297 @@
+// Package fat reads and writes msdos FAT12/16/32 filesystems
+package fat
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// printables
+const HexFMT = "0x%x"
+
+type HexByte uint8
+
+func (b HexByte) String() string {
+	return fmt.Sprintf(HexFMT, uint8(b))
+}
+
+type Hex2Byte [2]uint8
+
+func (b Hex2Byte) String() string {
+	var str string
+	for i, s := range b {
+		if i != 0 {
+			str += " "
+		}
+		str += fmt.Sprint(HexByte(s))
+	}
+	return fmt.Sprintf("|%s|", str)
+}
+
+type Hex3Byte [3]uint8
+
+func (b Hex3Byte) String() string {
+	var str string
+	for i, s := range b {
+		if i != 0 {
+			str += " "
+		}
+		str += fmt.Sprint(HexByte(s))
+	}
+	return fmt.Sprintf("|%s|", str)
+}
+
+type Str4Byte [4]uint8
+
+func (b Str4Byte) String() string {
+	var buf []byte
+	for _, s := range b {
+		buf = append(buf, byte(s))
+	}
+	return fmt.Sprintf("\"%s\"", string(buf))
+}
+
+type Str8Byte [8]uint8
+
+func (b Str8Byte) String() string {
+	var buf []byte
+	for _, s := range b {
+		buf = append(buf, byte(s))
+	}
+	return fmt.Sprintf("\"%s\"", string(buf))
+}
+
+type Str10Byte [10]uint8
+
+func (b Str10Byte) String() string {
+	var buf []byte
+	for _, s := range b {
+		buf = append(buf, byte(s))
+	}
+	return fmt.Sprintf("\"%s\"", string(buf))
+}
+
+type Str11Byte [11]uint8
+
+func (b Str11Byte) String() string {
+	var buf []byte
+	for _, s := range b {
+		buf = append(buf, byte(s))
+	}
+	return fmt.Sprintf("\"%s\"", string(buf))
+}
+
+type Str12Byte [12]uint8
+
+func (b Str12Byte) String() string {
+	var buf []byte
+	for _, s := range b {
+		buf = append(buf, byte(s))
+	}
+	return fmt.Sprintf("\"%s\"", string(buf))
+}
+
+// FAT header
+type BPB struct {
+	JumpBoot            Hex3Byte
+	OEMName             Str8Byte
+	BytesPerSector      uint16
+	SectorPerCluster    uint8
+	ReservedSectorCount uint16
+	NFATs               uint8
+	RootEntryCount      uint16
+	TotalSectors16      uint16
+	Media               HexByte
+	FATsz16             uint16 // number of sectors per FAT
+	SectorPerTrack      uint16
+	NumberHeads         uint16
+	HiddenSectors       uint32
+	TotalSectors32      uint32
+}
+
+type BPBExt16 struct {
+	DriveNumber   uint8
+	Reserved      uint8
+	BootSignature uint8
+	VolumenID     uint32
+	VolumenLabel  Str11Byte
+	FSType        Str8Byte
+	Empty         [448]uint8
+	SignatureWord Hex2Byte
+}
+
+type BPBExt32 struct {
+	FATsz32  uint32 // number of sectors per FAT (FAT32 only)
+	ExtFlags [2]uint8
+	FSVer    [2]uint8
+	// RootCluster is the cluster number where the root directory begins inside the data region
+	RootCluster   uint32
+	FSInfo        uint16
+	BkBootSec     uint16
+	Reserved      [12]uint8
+	DriveNum      uint8
+	Reserved1     uint8
+	BootSignature uint8
+	VolumenID     uint32
+	VolumenLabel  Str11Byte
+	FSType        Str8Byte
+	Empty         [420]uint8
+	SignatureWord Hex2Byte
+}
+
+// FSInfo is the FAT32 sector that caches the free cluster count and
+// where to start looking for a free cluster
+type FSInfo struct {
+	LeadSig   uint32
+	Reserved1 [480]uint8
+	StrucSig  uint32
+	FreeCount uint32 // last known free cluster count, 0xffffffff if unknown
+	NextFree  uint32 // hint of where to look for free clusters, 0xffffffff if unknown
+	Reserved2 [12]uint8
+	TrailSig  uint32
+}
+
+// FATInfo is a helper struct that saves useful information calculated with headers' info
+type FATInfo struct {
+	Type           uint8
+	Warning        string
+	FATNumber      uint32
+	FATSectors     uint32
+	FATOffset      uint32
+	RootDirSectors uint32
+	RootDirOffset  uint32
+	DataSectors    uint32
+	DataOffset     uint32
+	TotalSectors   uint32
+	SectorSize     uint32
+	ClusterCount   uint32
+	ClusterSize    uint32
+}
+
+// dir/file entries
+type DirEntry struct {
+	Name    Str11Byte
+	Attr    HexByte
+	NTRes   uint8  // reserved by windows NT, it keeps the case flags in it
+	CTTenth uint8  // creation time. count tenths of a second 0 <= CCTenth <= 199
+	CTime   uint16 // creation time. granularity is 2s
+	CDate   uint16 // creation date
+	// last accessed date.
+	// This field must be updated on file modification (write operation)
+	// and the date value must be equal to WDate.
+	LDate uint16
+	// High word of first data cluster number for file/directory described by this entry.
+	// Only valid for volumes formatted FAT32. Must be set to 0 on volumes formatted FAT12/FAT16.
+	FirstClusterHI uint16
+	WTime          uint16 // write time (must be equal to CTime at creation)
+	WDate          uint16 // write date (must be equal to CDate at creation)
+	// Low word of first data cluster number for file/dir described by this entry
+	FirstClusterLO uint16
+	FileSize       uint32
+}
+
+type DirEntryLong struct {
+	// order of the long name entry. the contents of the fields must be masked with 0x40
+	Ordinal HexByte
+	// for the last long directory name in the set
+	Name1          Str10Byte // first 5 chars in name
+	Attr           HexByte
+	Type           uint8 // Reserved (set to 0)
+	Checksum       uint8
+	Name2          Str12Byte // 6 more chars in name
+	FirstClusterLO uint16    // must be set to 0
+	Name3          Str4Byte  // last 2 chars in name
+}
+
+type EntryInfo struct {
+	ShortName string
+	LongName  string
+	Attr      HexByte
+	Crt       time.Time // creation, 10ms precision
+	Mod       time.Time // last write, 2s precision
+	Acc       time.Time // last access, only the date is kept
+	Location  uint32
+	Size      uint32
+	Entry     DirEntry // raw short entry as stored on disk
+	Kind      SlotKind
+
+	dir   uint32 // first cluster of the directory holding the entry, 0 for root
+	slot  int    // index of the short entry slot inside the directory
+	nlong int    // long entries stored right before the short entry
+}
+
+// legal file attributes
+const (
+	AttrRO     = 0x01 // file cannot be modified - all modification requests should fail
+	AttrHidden = 0x02 // the file or subdir should not be listed unless explicitly requested
+	AttrSystem = 0x04 // same as above but the request should be about "system files"
+	// The corresponding entry contains the volume
+	// label. DIR_FstClusHI and DIR_FstClusLO
+	// must always be 0 for the corresponding entry
+	// (representing the volume label) since no
+	// clusters can be allocated for this entry.
+	// Only the root directory (see Section 6.x below)
+	// can contain one entry with this attribute. No
+	// sub-directory must contain an entry of this type.
+	// Entries representing long file names (see
+	// Section 7) are exceptions to these rules.
+	AttrVolID = 0x08
+	// The corresponding entry represents a directory
+	// (a child or sub-directory to the containing
+	// directory).
+	// DIR_FileSize for the corresponding entry
+	// must always be 0 (even though clusters may
+	// have been allocated for the directory).
+	AttrDir = 0x10
+	// documentation says this should be set when when the file is
+	// created, renamed, or modified but it seems to be used for regular files, idk
+	AttrArchive  = 0x20
+	AttrLongName = AttrRO | AttrHidden | AttrSystem | AttrVolID // long filename entry
+
+	AttrEnd = 0x0 // not a real attribute
+)
+
+// long filename
+const (
+	LastEntryLong = 0x40
+)
+
+// NTRes flags telling that the base name or the extension of a short name
+// without long name are lowercase
+const (
+	NTResLowerBase = 0x08
+	NTResLowerExt  = 0x10
+)
+
+const (
+	FAT12 = iota
+	FAT16
+	FAT32
+)
+
+const RootEntrySize = 32
+
+// errors returned by the volume operations
+var (
+	ErrNotFAT   = errors.New("not a msdos FAT FS")
+	ErrNotFound = errors.New("entry not found")
+	ErrNoSpace  = errors.New("no more empty entries left")
+	ErrReadOnly = errors.New("volume opened read only")
+	ErrBadChain = errors.New("broken cluster chain")
+	ErrNotDir   = errors.New("not a directory")
+	ErrIsDir    = errors.New("is a directory")
+	ErrDirFull  = errors.New("root directory full")
+	ErrNotEmpty = errors.New("directory not empty")
+	ErrExists   = errors.New("entry already exists")
+	ErrMoveLoop = errors.New("cannot move a directory inside itself")
+
+	ErrBadOffset  = errors.New("invalid offset")
+	ErrFileTooBig = errors.New("file too big")
+	ErrNoFSInfo   = errors.New("volume has no valid FSInfo sector")
+
+	ErrNoPartitions = errors.New("no partition table found")
+	ErrNoPartition  = errors.New("partition not found")
+	ErrBadGPT       = errors.New("corrupted GPT")
+	ErrPartitionEnd = errors.New("write past the end of the partition")
+)
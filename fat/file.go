@@ -0,0 +1,506 @@
+package fat
+
+import (
+	"io"
+	"math"
+	"time"
+)
+
+// bytes read at once by Extract when the clusters are contiguous
+const extractBufferSize = 1 << 20
+
+// Extract writes the content of the file at path into w. The file is streamed
+// and runs of contiguous clusters are read at once
+func (v *Volume) Extract(path string, w io.Writer) (err error) {
+	fileInfo, err := v.Lookup(path)
+	if err != nil {
+		return
+	}
+
+	if fileInfo.Attr&AttrDir != 0 {
+		return ErrIsDir
+	}
+
+	return v.extract(fileInfo, w)
+}
+
+// extract streams the content of the file described by fileInfo into w
+func (v *Volume) extract(fileInfo EntryInfo, w io.Writer) (err error) {
+	cs := int64(v.Info.ClusterSize)
+	// the buffer holds whole clusters and is never bigger than the file
+	buf := make([]byte, min(max(cs, extractBufferSize/cs*cs), (int64(fileInfo.Size)+cs-1)/cs*cs))
+	fat := v.newFATReader()
+
+	location := fileInfo.Location
+
+	// empty files have no clusters
+	for remaining := int64(fileInfo.Size); remaining > 0; {
+		if !v.validCluster(location) {
+			return ErrBadChain
+		}
+
+		// grow the run while the next cluster comes right after the
+		// last one and there's room left in the buffer
+		start, run := location, cs
+
+		var next uint32
+		for {
+			if next, err = fat.entry(location); err != nil {
+				return
+			}
+
+			if run >= remaining || run+cs > int64(len(buf)) || next != location+1 || !v.validCluster(next) {
+				break
+			}
+
+			location = next
+			run += cs
+		}
+
+		// only the last cluster of the file is trimmed
+		n := min(run, remaining)
+
+		if err = v.readBytes(int64(getFileOffset(start, v.BPB, v.Info)), buf[:n]); err != nil {
+			return
+		}
+
+		if _, err = w.Write(buf[:n]); err != nil {
+			return
+		}
+
+		remaining -= n
+		location = next
+	}
+
+	return
+}
+
+// WriteFile creates a new file at path with the content read from input.
+// Every directory in path must already exist, the file itself must not
+func (v *Volume) WriteFile(path string, input io.Reader) (err error) {
+	return v.writeFile(path, input, AttrArchive, time.Time{})
+}
+
+// WriteFileTime is WriteFile with the modification time stored for the new
+// file, a zero mod means now
+func (v *Volume) WriteFileTime(path string, input io.Reader, mod time.Time) error {
+	return v.writeFile(path, input, AttrArchive, mod)
+}
+
+// writeFile is WriteFile with the attributes and the modification time of the
+// new file, a zero mod means now
+func (v *Volume) writeFile(path string, input io.Reader, attr HexByte, mod time.Time) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	splited := splitPath(path)
+	if len(splited) == 0 {
+		return ErrNotFound
+	}
+	name := splited[len(splited)-1]
+
+	parent, err := v.lookupDir(splited[:len(splited)-1])
+	if err != nil {
+		return
+	}
+
+	if _, err = v.lookup(splited); err == nil {
+		return ErrExists
+	} else if err != ErrNotFound {
+		return
+	}
+
+	d, err := v.openDir(parent)
+	if err != nil {
+		return
+	}
+
+	shortName, err := v.uniqueShortName(parent, name, nil)
+	if err != nil {
+		return
+	}
+
+	longEntries, _, err := v.nameSlots(name, shortName)
+	if err != nil {
+		return
+	}
+
+	// look for room inside the directory before writing anything
+	index, err := v.findFreeSlots(&d, len(longEntries)+1)
+	if err != nil {
+		return
+	}
+
+	location, size, err := v.writeChain(0, input, 0)
+	if err != nil {
+		return
+	}
+
+	fileEntry := EntryInfo{
+		LongName: name,
+		Attr:     attr,
+		Mod:      mod,
+		Location: location,
+		Size:     size,
+		Entry:    DirEntry{Name: shortName},
+	}
+
+	// lastly we add file entry to its directory
+	if err = v.addFile(d, index, fileEntry); err != nil {
+		v.freeChain(location)
+	}
+
+	return
+}
+
+// OverwriteFile replaces the content of the file at path with the content
+// read from input. The clusters of the file are reused, the ones left over
+// are freed. The file is created if it doesn't exist
+func (v *Volume) OverwriteFile(path string, input io.Reader) (err error) {
+	entry, err := v.lookupFile(path)
+	if err == ErrNotFound {
+		return v.WriteFile(path, input)
+	}
+	if err != nil {
+		return
+	}
+
+	var clusters []uint32
+	if entry.Location != 0 {
+		if clusters, err = v.chain(entry.Location); err != nil {
+			return
+		}
+	}
+
+	chunk := make([]byte, v.Info.ClusterSize)
+	used := 0 // clusters of the old chain holding new content
+	done := false
+	var size uint32
+
+	for ; used < len(clusters) && !done; used++ {
+		n, inputErr := io.ReadFull(input, chunk)
+		if inputErr != nil && inputErr != io.ErrUnexpectedEOF && inputErr != io.EOF {
+			return inputErr
+		}
+
+		if n == 0 {
+			done = true
+			break
+		}
+		if uint64(size)+uint64(n) > math.MaxUint32 {
+			return ErrFileTooBig
+		}
+
+		if err = v.writeAt(int64(getFileOffset(clusters[used], v.BPB, v.Info)), chunk[:n]); err != nil {
+			return
+		}
+		size += uint32(n)
+		done = inputErr != nil
+	}
+
+	if done {
+		// the new content is shorter, cut the chain after it
+		if entry.Location, err = v.cutChain(entry.Location, clusters, used); err != nil {
+			return
+		}
+	} else {
+		first, n, err := v.writeChain(lastCluster(clusters), input, size)
+		if err != nil {
+			return err
+		}
+		if entry.Location == 0 {
+			entry.Location = first
+		}
+		size += n
+	}
+
+	entry.Size = size
+
+	return v.touch(&entry)
+}
+
+// AppendFile adds the content read from input at the end of the file at path.
+// The file is created if it doesn't exist
+func (v *Volume) AppendFile(path string, input io.Reader) (err error) {
+	entry, err := v.lookupFile(path)
+	if err == ErrNotFound {
+		return v.WriteFile(path, input)
+	}
+	if err != nil {
+		return
+	}
+
+	var clusters []uint32
+	if entry.Location != 0 {
+		if clusters, err = v.chain(entry.Location); err != nil {
+			return
+		}
+	}
+
+	// the content goes after the cluster holding the end of the file, the
+	// clusters past it are not part of the file
+	cs := v.Info.ClusterSize
+	keep := int((uint64(entry.Size) + uint64(cs) - 1) / uint64(cs))
+	switch {
+	case keep > len(clusters):
+		return ErrBadChain
+	case keep < len(clusters):
+		if entry.Location, err = v.cutChain(entry.Location, clusters, keep); err != nil {
+			return
+		}
+		if err = v.writeEntry(entry); err != nil {
+			return
+		}
+		clusters = clusters[:keep]
+	}
+
+	last := lastCluster(clusters)
+	done := false
+
+	// fill the room left inside the last cluster first
+	if used := entry.Size % cs; last != 0 && used != 0 {
+		chunk := make([]byte, cs-used)
+
+		n, inputErr := io.ReadFull(input, chunk)
+		if inputErr != nil && inputErr != io.ErrUnexpectedEOF && inputErr != io.EOF {
+			return inputErr
+		}
+		if uint64(entry.Size)+uint64(n) > math.MaxUint32 {
+			return ErrFileTooBig
+		}
+
+		offset := int64(getFileOffset(last, v.BPB, v.Info)) + int64(used)
+		if err = v.writeAt(offset, chunk[:n]); err != nil {
+			return
+		}
+		entry.Size += uint32(n)
+		done = inputErr != nil
+	}
+
+	if !done {
+		first, n, err := v.writeChain(last, input, entry.Size)
+		if err != nil {
+			return err
+		}
+		if entry.Location == 0 {
+			entry.Location = first
+		}
+		entry.Size += n
+	}
+
+	return v.touch(&entry)
+}
+
+// Truncate changes the size of the file at path. The clusters past size are
+// freed, if the file grows it's filled with zeros
+func (v *Volume) Truncate(path string, size uint32) (err error) {
+	entry, err := v.lookupFile(path)
+	if err != nil {
+		return
+	}
+
+	var clusters []uint32
+	if entry.Location != 0 {
+		if clusters, err = v.chain(entry.Location); err != nil {
+			return
+		}
+	}
+
+	cs := v.Info.ClusterSize
+	keep := int((uint64(size) + uint64(cs) - 1) / uint64(cs))
+
+	if size <= entry.Size {
+		if entry.Location, err = v.cutChain(entry.Location, clusters, keep); err != nil {
+			return
+		}
+	} else {
+		if entry.Location, _, err = v.growChain(entry.Location, clusters, entry.Size, keep); err != nil {
+			return
+		}
+	}
+
+	entry.Size = size
+
+	return v.touch(&entry)
+}
+
+// lookupFile returns the entry of the regular file at path
+func (v *Volume) lookupFile(path string) (entry EntryInfo, err error) {
+	if v.w == nil {
+		return entry, ErrReadOnly
+	}
+
+	if entry, err = v.lookup(splitPath(path)); err != nil {
+		return
+	}
+
+	if entry.Attr&AttrDir != 0 {
+		return entry, ErrIsDir
+	}
+
+	return
+}
+
+// Chtimes changes the access and modification times of the file or
+// directory at path like os.Chtimes does. A zero time leaves that one as it
+// is. Only the date of atime is kept
+func (v *Volume) Chtimes(path string, atime, mtime time.Time) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	e, err := v.lookup(splitPath(path))
+	if err != nil {
+		return
+	}
+
+	if !atime.IsZero() {
+		e.Entry.LDate, _, _ = timeToFatTime(atime, v.location())
+	}
+	if !mtime.IsZero() {
+		e.Entry.WDate, e.Entry.WTime, _ = timeToFatTime(mtime, v.location())
+	}
+
+	return v.writeEntry(e)
+}
+
+// touch stores e back into its slot marking it as modified now
+func (v *Volume) touch(e *EntryInfo) error {
+	e.Entry.WDate, e.Entry.WTime, _ = timeToFatTime(time.Now(), v.location())
+	e.Entry.LDate = e.Entry.WDate
+	e.Mod = fatTimeToTime(e.Entry.WDate, e.Entry.WTime, 0, v.location())
+	e.Acc = fatTimeToTime(e.Entry.LDate, 0, 0, v.location())
+	e.Attr |= AttrArchive
+
+	return v.writeEntry(*e)
+}
+
+func lastCluster(clusters []uint32) uint32 {
+	if len(clusters) == 0 {
+		return 0
+	}
+	return clusters[len(clusters)-1]
+}
+
+// cutChain keeps the first keep clusters of the chain starting at first and
+// frees the rest. It returns the new first cluster, 0 if nothing is kept
+func (v *Volume) cutChain(first uint32, clusters []uint32, keep int) (uint32, error) {
+	if keep >= len(clusters) {
+		return first, nil
+	}
+
+	if keep == 0 {
+		return 0, v.freeChain(first)
+	}
+
+	eof, _ := mkentry(v.Info.Type)
+	if err := v.setFATEntry(clusters[keep-1], eof); err != nil {
+		return first, err
+	}
+
+	return first, v.freeChain(clusters[keep])
+}
+
+// growChain extends the chain starting at first, holding size bytes, to want
+// zeroed clusters. Zeros also replace whatever is stored after size inside
+// the last cluster. It returns the new first cluster and the whole chain
+func (v *Volume) growChain(first uint32, clusters []uint32, size uint32, want int) (uint32, []uint32, error) {
+	have := len(clusters)
+	last := lastCluster(clusters)
+
+	if used := int64(size) - int64(have-1)*int64(v.Info.ClusterSize); last != 0 && used >= 0 && used < int64(v.Info.ClusterSize) {
+		offset := int64(getFileOffset(last, v.BPB, v.Info))
+		if err := v.zero(offset+used, int64(v.Info.ClusterSize)-used); err != nil {
+			return first, clusters, err
+		}
+	}
+
+	for len(clusters) < want {
+		cluster, err := v.allocCluster(last)
+		if err != nil {
+			// give the new clusters back
+			f, _ := v.cutChain(first, clusters, have)
+			return f, clusters[:have], err
+		}
+
+		if first == 0 {
+			first = cluster
+		}
+		clusters = append(clusters, cluster)
+		last = cluster
+
+		if err = v.zeroCluster(cluster); err != nil {
+			f, _ := v.cutChain(first, clusters, have)
+			return f, clusters[:have], err
+		}
+	}
+
+	if err := v.writeFSInfo(); err != nil {
+		f, _ := v.cutChain(first, clusters, have)
+		return f, clusters[:have], err
+	}
+
+	return first, clusters, nil
+}
+
+// writeChain stores everything read from input inside new clusters linked
+// after last, or inside a new chain if last is 0, and returns the first new
+// cluster. Empty inputs get no clusters at all. The file already holds
+// offset bytes, ErrFileTooBig is returned if the input doesn't fit after them
+func (v *Volume) writeChain(last uint32, input io.Reader, offset uint32) (first, size uint32, err error) {
+	location := last
+
+	chunk := make([]byte, v.Info.ClusterSize)
+
+	for {
+		// read from out input file into buffer
+		n, inputErr := io.ReadFull(input, chunk)
+		if inputErr != nil && inputErr != io.ErrUnexpectedEOF && inputErr != io.EOF {
+			err = inputErr
+			break
+		}
+
+		if n == 0 {
+			break
+		}
+		if uint64(offset)+uint64(size)+uint64(n) > math.MaxUint32 {
+			err = ErrFileTooBig
+			break
+		}
+
+		// link a new cluster after the last one
+		if location, err = v.allocCluster(location); err != nil {
+			break
+		}
+		if first == 0 {
+			first = location
+		}
+
+		// write into FS
+		if err = v.writeAt(int64(getFileOffset(location, v.BPB, v.Info)), chunk[:n]); err != nil {
+			break
+		}
+		size += uint32(n)
+
+		if inputErr != nil {
+			break
+		}
+	}
+
+	if err == nil && first != 0 {
+		err = v.writeFSInfo()
+	}
+
+	if err != nil && first != 0 {
+		// don't leave half a file allocated
+		if last != 0 {
+			eof, _ := mkentry(v.Info.Type)
+			v.setFATEntry(last, eof)
+		}
+		v.freeChain(first)
+		return 0, 0, err
+	}
+
+	return first, size, nil
+}
@@ -0,0 +1,323 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// extractPerCluster is how Extract worked before streaming: a read and a FAT
+// lookup for every cluster and the whole file kept in memory. It's the
+// baseline of BenchmarkExtract
+func extractPerCluster(v *Volume, path string, w io.Writer) (err error) {
+	fileInfo, err := v.Lookup(path)
+	if err != nil || fileInfo.Size == 0 {
+		return
+	}
+
+	location := fileInfo.Location
+	var b []byte
+
+	for {
+		if !v.validCluster(location) {
+			return ErrBadChain
+		}
+
+		chunk := make([]byte, v.Info.ClusterSize)
+		if err = v.readAt(int64(getFileOffset(location, v.BPB, v.Info)), chunk); err != nil {
+			return
+		}
+		b = append(b, chunk...)
+
+		if location, err = v.FATEntry(location); err != nil {
+			return
+		}
+		if isEOF(v.Info.Type, location) {
+			break
+		}
+	}
+
+	_, err = w.Write(b[:fileInfo.Size])
+
+	return
+}
+
+// extractImage returns a volume holding "contiguous" and "fragmented", two
+// files of size bytes. The clusters of the second one are interleaved with
+// the ones of another file
+func extractImage(t testing.TB, size int) (v *Volume, content []byte) {
+	v, _ = newImage(t, int64(size)*3+(8<<20), FormatOptions{FATBits: 16})
+
+	content = make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	if err := v.WriteFile("contiguous", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	fragmented, err := v.OpenFile("fragmented", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filler, err := v.OpenFile("filler", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := int(v.Info.ClusterSize)
+	for i := 0; i < size; i += cs {
+		if _, err = fragmented.Write(content[i:min(i+cs, size)]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = filler.Write(content[:cs]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return v, content
+}
+
+func TestExtract(t *testing.T) {
+	v, content := extractImage(t, 1<<20+123)
+
+	for _, name := range []string{"contiguous", "fragmented"} {
+		var out bytes.Buffer
+		if err := v.Extract(name, &out); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Fatalf("%s: content differs", name)
+		}
+	}
+
+	e, err := v.Lookup("fragmented")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := v.chain(e.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(clusters); i++ {
+		if clusters[i] == clusters[i-1]+1 {
+			t.Fatalf("clusters %d and %d are contiguous", clusters[i-1], clusters[i])
+		}
+	}
+
+	// a size past the end of the chain
+	e.Size += 1 << 20
+	if err = v.writeEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Extract("fragmented", io.Discard); err != ErrBadChain {
+		t.Fatalf("got %v, want %v", err, ErrBadChain)
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	const size = 64 << 20
+
+	v, _ := extractImage(b, size)
+
+	extractors := []struct {
+		name    string
+		extract func(v *Volume, path string, w io.Writer) error
+	}{
+		{"streaming", (*Volume).Extract},
+		{"per-cluster", extractPerCluster},
+	}
+
+	for _, file := range []string{"contiguous", "fragmented"} {
+		for _, e := range extractors {
+			b.Run(file+"/"+e.name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(size)
+
+				for range b.N {
+					if err := e.extract(v, file, io.Discard); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// checkFile compares the content of the file at path with want and the
+// length of its chain with the clusters want needs
+func checkFile(t *testing.T, v *Volume, path string, want []byte) {
+	t.Helper()
+
+	b, err := v.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("%s: read %d bytes, want %d", path, len(b), len(want))
+	}
+
+	e, err := v.Lookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clusters []uint32
+	if e.Location != 0 {
+		if clusters, err = v.chain(e.Location); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := int(v.Info.ClusterSize)
+	if need := (len(want) + cs - 1) / cs; len(clusters) != need {
+		t.Fatalf("%s: %d clusters, want %d", path, len(clusters), need)
+	}
+
+	checkClean(t, v, path)
+}
+
+func TestOverwriteFile(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := int(v.Info.ClusterSize)
+			for _, size := range []int{3 * cs, cs + cs/2, 5 * cs, cs, 0, 2*cs + 1} {
+				content := bytes.Repeat([]byte{byte(size)}, size)
+				if err = v.OverwriteFile("file", bytes.NewReader(content)); err != nil {
+					t.Fatal(err)
+				}
+				checkFile(t, v, "file", content)
+				checkFree(t, v, free-uint32((size+cs-1)/cs))
+			}
+		})
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			cs := int(v.Info.ClusterSize)
+			var content []byte
+
+			// empty, at a cluster boundary, inside a cluster and across
+			// clusters
+			for _, size := range []int{0, cs, 3, cs - 3, cs + 10, 2 * cs} {
+				more := bytes.Repeat([]byte{byte(len(content))}, size)
+				if err := v.AppendFile("file", bytes.NewReader(more)); err != nil {
+					t.Fatal(err)
+				}
+				content = append(content, more...)
+				checkFile(t, v, "file", content)
+			}
+		})
+	}
+}
+
+func TestAppendFileLongChain(t *testing.T) {
+	v, _ := newImage(t, 16<<20, FormatOptions{})
+
+	free, err := v.FreeClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := int(v.Info.ClusterSize)
+	content := make([]byte, 3*cs)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err = v.WriteFile("file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain is longer than the size says
+	e, err := v.Lookup("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Size = uint32(cs + 10)
+	if err = v.writeEntry(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.AppendFile("file", strings.NewReader("xyz")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, v, "file", append(content[:cs+10:cs+10], "xyz"...))
+	checkFree(t, v, free-2)
+
+	// and shorter
+	e, _ = v.Lookup("file")
+	e.Size = uint32(2*cs + 1)
+	if err = v.writeEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.AppendFile("file", strings.NewReader("xyz")); err != ErrBadChain {
+		t.Fatalf("got %v, want %v", err, ErrBadChain)
+	}
+}
+
+func TestWriteChainTooBig(t *testing.T) {
+	v, _ := newImage(t, 16<<20, FormatOptions{})
+
+	free, err := v.FreeClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := v.Info.ClusterSize
+	input := bytes.NewReader(make([]byte, 2*cs))
+	if _, _, err = v.writeChain(0, input, math.MaxUint32-cs); err != ErrFileTooBig {
+		t.Fatalf("got %v, want %v", err, ErrFileTooBig)
+	}
+	checkFree(t, v, free)
+
+	input = bytes.NewReader(make([]byte, cs))
+	first, size, err := v.writeChain(0, input, math.MaxUint32-cs)
+	if err != nil || first == 0 || size != cs {
+		t.Fatalf("cluster %d size %d, %v", first, size, err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := int(v.Info.ClusterSize)
+			content := bytes.Repeat([]byte{0xaa}, cs+10)
+			if err = v.WriteFile("file", bytes.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+
+			// the bytes after the size inside the last cluster must not
+			// come back when growing
+			for _, size := range []int{cs + 5, 3*cs + 5, cs, 0, 2 * cs} {
+				if err = v.Truncate("file", uint32(size)); err != nil {
+					t.Fatal(err)
+				}
+				if size < len(content) {
+					content = content[:size]
+				} else {
+					content = append(content, make([]byte, size-len(content))...)
+				}
+				checkFile(t, v, "file", content)
+				checkFree(t, v, free-uint32((size+cs-1)/cs))
+			}
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package fat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// FormatOptions configures Format. Zero values are replaced by defaults
+// picked from the volume size
+type FormatOptions struct {
+	FATBits           int            // 12, 16 or 32. picked from the volume size if 0
+	SectorSize        uint16         // 512 if 0
+	SectorsPerCluster uint8          // picked from the volume size if 0
+	ReservedSectors   uint16         // 1 for FAT12/16 and 32 for FAT32 if 0
+	NFATs             uint8          // 2 if 0
+	RootEntries       uint16         // FAT12/16 only. 512 if 0, 224 for floppy sized FAT12 volumes
+	Media             uint8          // 0xf8 if 0
+	OEMName           string         // "MSWIN4.1" if empty
+	Label             string         // "NO NAME" if empty
+	CodePage          CodePage       // the label is stored in it
+	Serial            uint32         // taken from the clock if 0
+	HiddenSectors     uint32         // sectors before the volume when it's inside a partition
+	Location          *time.Location // time zone of the label timestamp, time.Local if nil
+}
+
+// sectors per cluster recommended by microsoft for FAT16 and FAT32
+// volumes up to a size given in 512 byte sectors
+type clusterSizeRow struct {
+	sectors uint64
+	spc     uint8
+}
+
+var (
+	fat16ClusterSizes = []clusterSizeRow{
+		{8400, 1},
+		{32680, 2},
+		{262144, 4},
+		{524288, 8},
+		{1048576, 16},
+		{2097152, 32},
+		{4194304, 64},
+	}
+	fat32ClusterSizes = []clusterSizeRow{
+		{532480, 1},
+		{16777216, 8},
+		{33554432, 16},
+		{67108864, 32},
+		{0xffffffff, 64},
+	}
+)
+
+// cluster count limits of each FAT type, anything outside would be detected
+// as another type
+const (
+	maxClustersFAT12 = 4084
+	maxClustersFAT16 = 65524
+	maxClustersFAT32 = 0xffffff4
+)
+
+// Format writes an empty FAT filesystem of size bytes into w. It writes the
+// reserved region, the FATs and the root directory, the data region is left
+// untouched
+func Format(w io.WriterAt, size int64, opts FormatOptions) (err error) {
+	if opts.SectorSize == 0 {
+		opts.SectorSize = 512
+	}
+	switch opts.SectorSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return fmt.Errorf("invalid sector size %d", opts.SectorSize)
+	}
+
+	ss := uint64(opts.SectorSize)
+	total := uint64(size) / ss
+	if total > 0xffffffff {
+		return errors.New("volume too big")
+	}
+
+	// the recommendations are given for 512 byte sectors
+	size512 := total * ss / 512
+
+	if opts.NFATs == 0 {
+		opts.NFATs = 2
+	}
+	if opts.Media == 0 {
+		opts.Media = 0xf8
+	}
+	if opts.OEMName == "" {
+		opts.OEMName = "MSWIN4.1"
+	}
+	if opts.Serial == 0 {
+		opts.Serial = uint32(time.Now().Unix())
+	}
+
+	if opts.Media < 0xf0 || opts.Media > 0xf0 && opts.Media < 0xf8 {
+		return fmt.Errorf("invalid media byte 0x%x", opts.Media)
+	}
+
+	label, err := volumeLabel(opts.Label, opts.CodePage)
+	if err != nil {
+		return
+	}
+
+	// the type is picked from the size but the clusters left after scaling
+	// the cluster size to the sector size might not fit it, the other
+	// types are tried then
+	types := []int{opts.FATBits}
+	if opts.FATBits == 0 {
+		switch {
+		case size512 <= 8400:
+			types = []int{12, 16, 32}
+		case size512 <= 1048576:
+			types = []int{16, 12, 32}
+		default:
+			types = []int{32, 16, 12}
+		}
+	}
+
+	var l layout
+	for i, bits := range types {
+		try := opts
+		try.FATBits = bits
+
+		var tryErr error
+		if l, tryErr = newLayout(&try, total, size512); tryErr == nil {
+			opts, err = try, nil
+			break
+		}
+		if i == 0 {
+			err = tryErr
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	fatType, spc, meta, clusters := l.fatType, uint64(opts.SectorsPerCluster), l.meta, l.clusters
+	rootSectors, fatSectors := l.rootSectors, l.fatSectors
+
+	bpb := BPB{
+		JumpBoot:            Hex3Byte{0xeb, 0x3c, 0x90},
+		BytesPerSector:      opts.SectorSize,
+		SectorPerCluster:    opts.SectorsPerCluster,
+		ReservedSectorCount: opts.ReservedSectors,
+		NFATs:               opts.NFATs,
+		RootEntryCount:      opts.RootEntries,
+		Media:               HexByte(opts.Media),
+		SectorPerTrack:      32,
+		NumberHeads:         64,
+		HiddenSectors:       opts.HiddenSectors,
+	}
+	copy(bpb.OEMName[:], fmt.Sprintf("%-8s", opts.OEMName))
+
+	if total < 0x10000 && fatType != FAT32 {
+		bpb.TotalSectors16 = uint16(total)
+	} else {
+		bpb.TotalSectors32 = uint32(total)
+	}
+
+	driveNumber := uint8(0x80)
+	if opts.Media == 0xf0 {
+		driveNumber = 0
+	}
+
+	var ext any
+	switch fatType {
+	case FAT12, FAT16:
+		bpb.FATsz16 = uint16(fatSectors)
+
+		ext16 := BPBExt16{
+			DriveNumber:   driveNumber,
+			BootSignature: 0x29,
+			VolumenID:     opts.Serial,
+			VolumenLabel:  label,
+			SignatureWord: Hex2Byte{0x55, 0xaa},
+		}
+		copy(ext16.FSType[:], fmt.Sprintf("FAT%-5d", opts.FATBits))
+		ext = ext16
+	case FAT32:
+		bpb.JumpBoot = Hex3Byte{0xeb, 0x58, 0x90}
+
+		ext32 := BPBExt32{
+			FATsz32:       uint32(fatSectors),
+			RootCluster:   2,
+			FSInfo:        1,
+			BkBootSec:     6,
+			DriveNum:      driveNumber,
+			BootSignature: 0x29,
+			VolumenID:     opts.Serial,
+			VolumenLabel:  label,
+			SignatureWord: Hex2Byte{0x55, 0xaa},
+		}
+		copy(ext32.FSType[:], "FAT32   ")
+		ext = ext32
+	}
+
+	v := &Volume{w: w, Location: opts.Location}
+
+	// start from a clean reserved region, FATs and root directory
+	if err = v.zero(0, int64(meta*ss)); err != nil {
+		return
+	}
+
+	if err = v.writeAt(0, bpb); err != nil {
+		return
+	}
+	if err = v.writeAt(36, ext); err != nil {
+		return
+	}
+
+	// FAT[0] holds the media byte and FAT[1] an end of chain marker with
+	// the clean shutdown bits set
+	fat := []uint32{0xf00 | uint32(opts.Media), 0xfff}
+	switch fatType {
+	case FAT16:
+		fat = []uint32{0xff00 | uint32(opts.Media), 0xffff}
+	case FAT32:
+		// the root directory takes the first cluster
+		fat = []uint32{0xfffff00 | uint32(opts.Media), 0xfffffff, 0xfffffff}
+	}
+
+	raw := make([]byte, fatBytes(fatType, uint64(len(fat))))
+	_, fatEntry := mkentry(fatType)
+	for n, value := range fat {
+		info := FATInfo{Type: fatType}
+		offset := getFATEntryOffset(uint32(n), len(fatEntry), info)
+		putLocToEntry(fatType, raw[offset:], uint32(n), value)
+	}
+
+	for i := uint64(0); i < uint64(opts.NFATs); i++ {
+		offset := (uint64(opts.ReservedSectors) + i*fatSectors) * ss
+		if err = v.writeAt(int64(offset), raw); err != nil {
+			return
+		}
+	}
+
+	// the root directory of FAT12/16 was already zeroed with the rest of
+	// the metadata, FAT32 needs its root cluster cleared
+	root := int64(meta * ss)
+	if fatType == FAT32 {
+		if err = v.zero(root, int64(spc*ss)); err != nil {
+			return
+		}
+
+		fsInfo := FSInfo{
+			LeadSig:   FSInfoLeadSig,
+			StrucSig:  FSInfoStrucSig,
+			FreeCount: uint32(clusters - 1),
+			NextFree:  3,
+			TrailSig:  FSInfoTrailSig,
+		}
+
+		// the backup boot sector is followed by a backup of the FSInfo
+		for _, sector := range []int64{1, 7} {
+			if err = v.writeAt(sector*int64(ss), fsInfo); err != nil {
+				return
+			}
+		}
+		if err = v.writeAt(6*int64(ss), bpb); err != nil {
+			return
+		}
+		if err = v.writeAt(6*int64(ss)+36, ext); err != nil {
+			return
+		}
+	} else {
+		root -= int64(rootSectors * ss)
+	}
+
+	if opts.Label != "" {
+		date, tm, _ := timeToFatTime(time.Now(), v.location())
+		err = v.writeAt(root, DirEntry{
+			Name:  label,
+			Attr:  AttrVolID,
+			WTime: tm,
+			WDate: date,
+		})
+	}
+
+	return
+}
+
+// layout tells where the regions of a volume go, in sectors
+type layout struct {
+	fatType     uint8
+	rootSectors uint64
+	fatSectors  uint64
+	meta        uint64 // reserved sectors, FATs and FAT12/16 root directory
+	clusters    uint64
+}
+
+// newLayout fills the defaults of opts that depend on the FAT type and lays
+// out a volume of total sectors. It fails if the clusters left don't make a
+// volume of that type
+func newLayout(opts *FormatOptions, total, size512 uint64) (l layout, err error) {
+	switch opts.FATBits {
+	case 12:
+		l.fatType = FAT12
+	case 16:
+		l.fatType = FAT16
+	case 32:
+		l.fatType = FAT32
+	default:
+		return l, fmt.Errorf("invalid FAT type %d", opts.FATBits)
+	}
+
+	if opts.ReservedSectors == 0 {
+		opts.ReservedSectors = 1
+		if l.fatType == FAT32 {
+			opts.ReservedSectors = 32
+		}
+	}
+
+	switch {
+	case l.fatType == FAT32:
+		// FAT32 keeps its root directory in the data region
+		opts.RootEntries = 0
+	case opts.RootEntries == 0 && l.fatType == FAT12 && size512 <= 2880:
+		opts.RootEntries = 224
+	case opts.RootEntries == 0:
+		opts.RootEntries = 512
+	}
+
+	if l.fatType == FAT32 && opts.ReservedSectors < 8 {
+		return l, errors.New("FAT32 needs at least 8 reserved sectors")
+	}
+
+	ss := uint64(opts.SectorSize)
+	l.rootSectors = (uint64(opts.RootEntries)*RootEntrySize + ss - 1) / ss
+	l.meta = uint64(opts.ReservedSectors) + l.rootSectors
+
+	if opts.SectorsPerCluster == 0 {
+		size := defaultClusterSize(l.fatType, size512, total, l.meta, ss)
+		opts.SectorsPerCluster = uint8(max(size/ss, 1))
+	}
+
+	spc := uint64(opts.SectorsPerCluster)
+	if spc&(spc-1) != 0 {
+		return l, fmt.Errorf("sectors per cluster must be a power of 2, not %d", spc)
+	}
+
+	// the FAT is sized for every cluster there would be without it, that
+	// leaves a few unused entries but it's always big enough
+	if total <= l.meta {
+		return l, errors.New("volume too small")
+	}
+	l.fatSectors = (fatBytes(l.fatType, (total-l.meta)/spc+2) + ss - 1) / ss
+
+	l.meta += uint64(opts.NFATs) * l.fatSectors
+	if total <= l.meta {
+		return l, errors.New("volume too small")
+	}
+	l.clusters = (total - l.meta) / spc
+
+	var minClusters, maxClusters uint64
+	switch l.fatType {
+	case FAT12:
+		minClusters, maxClusters = 1, maxClustersFAT12
+	case FAT16:
+		minClusters, maxClusters = maxClustersFAT12+1, maxClustersFAT16
+	case FAT32:
+		minClusters, maxClusters = maxClustersFAT16+1, maxClustersFAT32
+	}
+	if l.clusters < minClusters || l.clusters > maxClusters {
+		return l, fmt.Errorf("%d clusters of %d sectors don't make a FAT%d volume", l.clusters, spc, opts.FATBits)
+	}
+
+	if l.fatType != FAT32 && l.fatSectors > 0xffff {
+		return l, errors.New("FAT too big, use bigger clusters")
+	}
+
+	return
+}
+
+// defaultClusterSize picks the cluster size in bytes of a volume with size512
+// 512 byte sectors, total sectors of ss bytes and meta sectors before the
+// FATs. FAT12 isn't covered by the microsoft tables so it uses the smallest
+// cluster that keeps the count under the FAT12 limit
+func defaultClusterSize(fatType uint8, size512, total, meta, ss uint64) uint64 {
+	switch fatType {
+	case FAT12:
+		spc := uint64(1)
+		for spc < 128 && (total-min(meta, total))/spc > maxClustersFAT12 {
+			spc *= 2
+		}
+		return spc * ss
+	case FAT16:
+		for _, row := range fat16ClusterSizes {
+			if size512 <= row.sectors {
+				return uint64(row.spc) * 512
+			}
+		}
+		return 128 * 512
+	}
+
+	for _, row := range fat32ClusterSizes {
+		if size512 <= row.sectors {
+			return uint64(row.spc) * 512
+		}
+	}
+	return 128 * 512
+}
+
+// fatBytes returns how many bytes a FAT with n entries takes
+func fatBytes(t uint8, n uint64) uint64 {
+	switch t {
+	case FAT12:
+		return (n*3 + 1) / 2
+	case FAT16:
+		return n * 2
+	}
+	return n * 4
+}
+
+// volumeLabel converts label into its 11 byte form in the code page cp
+// padded with spaces
+func volumeLabel(label string, cp CodePage) (name Str11Byte, err error) {
+	if label == "" {
+		label = "NO NAME"
+	}
+
+	var b []byte
+	for _, r := range strings.ToUpper(label) {
+		c, ok := cp.encodeRune(r)
+		if r < 0x80 {
+			_, ok = validChars[byte(r)]
+		}
+		if !ok && r != ' ' {
+			return name, fmt.Errorf("invalid character %q in label", r)
+		}
+		b = append(b, c...)
+	}
+
+	if len(b) > len(name) {
+		return name, errors.New("label longer than 11 characters")
+	}
+	if b[0] == ' ' {
+		return name, errors.New("label cannot start with a space")
+	}
+
+	copy(name[:], "           ")
+	copy(name[:], b)
+
+	if name[0] == NameDeleted {
+		name[0] = NameKanji
+	}
+
+	return
+}
+
+// zero fills size bytes of the volume starting at offset with zeros
+func (v *Volume) zero(offset, size int64) error {
+	chunk := make([]byte, min(size, 64*1024))
+
+	for size > 0 {
+		n := min(size, int64(len(chunk)))
+		if err := v.writeAt(offset, chunk[:n]); err != nil {
+			return err
+		}
+		offset += n
+		size -= n
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package fat
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFormatSweep(t *testing.T) {
+	cases := []struct {
+		bits int
+		size int64
+	}{
+		// the type is picked from the size
+		{0, 360 << 10},
+		{0, 1440 << 10},
+		{0, 8400*512 - 512},
+		{0, 8400 * 512},
+		{0, 8400*512 + 512},
+		{0, 4600 << 10},
+		{0, 8 << 20},
+		{0, 14 << 20},
+		{0, 32 << 20},
+		{0, 1048576*512 - 4096},
+		{0, 1048576 * 512},
+		{0, 1048576*512 + 4096},
+
+		// the type is given
+		{12, 1440 << 10},
+		{12, 8 << 20},
+		{16, 64 << 20},
+		{16, 1 << 30},
+		{32, 300 << 20},
+		{32, 1 << 30},
+	}
+
+	for _, ss := range []uint16{512, 1024, 2048, 4096} {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("FAT%d/%d/%d", c.bits, ss, c.size), func(t *testing.T) {
+				img := make(memImage, c.size)
+				if err := Format(img, c.size, FormatOptions{FATBits: c.bits, SectorSize: ss}); err != nil {
+					t.Fatal(err)
+				}
+
+				v, err := New(img)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v.Info.Warning != "" {
+					t.Fatal(v.Info.Warning)
+				}
+				if c.bits != 0 && v.Info.Type != map[int]uint8{12: FAT12, 16: FAT16, 32: FAT32}[c.bits] {
+					t.Fatalf("formatted as type %d", v.Info.Type)
+				}
+
+				report, err := v.Check(CheckOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status := report.Status(); status != CheckClean {
+					t.Fatalf("%v: %v", status, report.Problems)
+				}
+			})
+		}
+	}
+}
+
+func TestFormatLabelTime(t *testing.T) {
+	for _, loc := range []*time.Location{time.FixedZone("east", 14*3600), time.FixedZone("west", -12*3600)} {
+		v, _ := newImage(t, 1440<<10, FormatOptions{Label: "TIMED", Location: loc})
+		v.Location = loc
+
+		entries, err := v.readDirSlots(0, SlotVolume)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("%d labels, %v", len(entries), err)
+		}
+
+		// read back in the same zone the label was stamped now
+		if d := time.Since(entries[0].Mod); d < -2*time.Second || d > time.Minute {
+			t.Errorf("%s: label stamped %v ago", loc, d)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the volume can be used anywhere an io/fs filesystem is expected
+var (
+	_ fs.FS         = (*Volume)(nil)
+	_ fs.ReadDirFS  = (*Volume)(nil)
+	_ fs.StatFS     = (*Volume)(nil)
+	_ fs.ReadFileFS = (*Volume)(nil)
+)
+
+// Open opens the named file or directory for reading
+func (v *Volume) Open(name string) (fs.File, error) {
+	entry, err := v.fsLookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Attr&AttrDir != 0 {
+		entries, err := v.fsReadDir("open", name)
+		if err != nil {
+			return nil, err
+		}
+		return &dirFile{info: fileInfo{entry}, entries: entries}, nil
+	}
+
+	return &File{v: v, name: name, entry: entry, flag: os.O_RDONLY}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by filename
+func (v *Volume) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := v.fsLookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Attr&AttrDir == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDir}
+	}
+
+	return v.fsReadDir("readdir", name)
+}
+
+// Stat returns a fs.FileInfo describing the named file
+func (v *Volume) Stat(name string) (fs.FileInfo, error) {
+	entry, err := v.fsLookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{entry}, nil
+}
+
+// ReadFile reads the whole named file
+func (v *Volume) ReadFile(name string) ([]byte, error) {
+	entry, err := v.fsLookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Attr&AttrDir != 0 {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: ErrIsDir}
+	}
+
+	var b bytes.Buffer
+	b.Grow(int(entry.Size))
+
+	if err = v.Extract(name, &b); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return b.Bytes(), nil
+}
+
+// fsLookup resolves an io/fs path into its entry. The root directory has no
+// entry on disk so one is made up for it
+func (v *Volume) fsLookup(op, name string) (entry EntryInfo, err error) {
+	if !fs.ValidPath(name) {
+		return EntryInfo{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == "." {
+		return EntryInfo{ShortName: ".", Attr: AttrDir}, nil
+	}
+
+	if entry, err = v.Lookup(name); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			err = fs.ErrNotExist
+		}
+		return EntryInfo{}, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return entry, nil
+}
+
+// fsReadDir lists the named directory
+func (v *Volume) fsReadDir(op, name string) ([]fs.DirEntry, error) {
+	entries, err := v.List(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	var dirEntries []fs.DirEntry
+	for _, e := range entries {
+		dirEntries = append(dirEntries, fs.FileInfoToDirEntry(fileInfo{e}))
+	}
+
+	slices.SortFunc(dirEntries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return dirEntries, nil
+}
+
+// fileInfo implements fs.FileInfo on top of an entry
+type fileInfo struct {
+	e EntryInfo
+}
+
+func (fi fileInfo) Name() string {
+	return fi.e.Name()
+}
+
+func (fi fileInfo) Size() int64 {
+	return int64(fi.e.Size)
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0o666)
+	if fi.e.Attr&AttrDir != 0 {
+		mode = fs.ModeDir | 0o777
+	}
+	if fi.e.Attr&AttrRO != 0 {
+		mode &^= 0o222
+	}
+	return mode
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	return fi.e.Mod
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.e.Attr&AttrDir != 0
+}
+
+// Sys returns the raw DirEntry
+func (fi fileInfo) Sys() any {
+	return fi.e.Entry
+}
+
+// dirFile is a directory opened through the io/fs interface
+type dirFile struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	closed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: ErrIsDir}
+}
+
+func (d *dirFile) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	if d.closed {
+		return nil, fs.ErrClosed
+	}
+
+	if n <= 0 {
+		entries, d.entries = d.entries, nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries, d.entries = d.entries[:n], d.entries[n:]
+
+	return entries, nil
+}
+
+func (d *dirFile) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,116 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// memImage is a disk image kept in memory
+type memImage []byte
+
+func (m memImage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m memImage) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(m)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(m[off:], p), nil
+}
+
+// newImage formats an image of size bytes in memory and opens it
+func newImage(t testing.TB, size int64, opts FormatOptions) (*Volume, memImage) {
+	t.Helper()
+
+	img := make(memImage, size)
+	if err := Format(img, size, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := New(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v, img
+}
+
+func TestFS(t *testing.T) {
+	for _, c := range []struct {
+		size int64
+		opts FormatOptions
+	}{
+		{1440 << 10, FormatOptions{FATBits: 12}},
+		{16 << 20, FormatOptions{FATBits: 16}},
+		{40 << 20, FormatOptions{FATBits: 32, SectorsPerCluster: 1}},
+	} {
+		v, _ := newImage(t, c.size, c.opts)
+		bits := c.opts.FATBits
+
+		files := map[string]string{
+			"HELLO.TXT":                      "hello world\n",
+			"empty":                          "",
+			"docs/A long file name.markdown": strings.Repeat("lorem ipsum ", 300),
+			"docs/Ünïcode 文件.txt":            "utf-8",
+			"docs/deep/er/NOTES":             strings.Repeat("x", 513),
+		}
+		for _, dir := range []string{"docs/deep/er", "empty dir"} {
+			if err := v.Mkdir(dir, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, content := range files {
+			if err := v.WriteFile(name, strings.NewReader(content)); err != nil {
+				t.Fatalf("FAT%d %s: %v", bits, name, err)
+			}
+		}
+
+		expected := []string{"empty dir"}
+		for name := range files {
+			expected = append(expected, name)
+		}
+		if err := fstest.TestFS(v, expected...); err != nil {
+			t.Fatalf("FAT%d: %v", bits, err)
+		}
+
+		for name, content := range files {
+			b, err := fs.ReadFile(v, name)
+			if err != nil || !bytes.Equal(b, []byte(content)) {
+				t.Fatalf("FAT%d %s: %q %v", bits, name, b, err)
+			}
+		}
+	}
+}
+
+func TestFSIsDir(t *testing.T) {
+	v, _ := newImage(t, 1440<<10, FormatOptions{})
+	if err := v.Mkdir("dir", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.ReadFile("dir"); !errors.Is(err, ErrIsDir) {
+		t.Errorf("ReadFile: got %v, want %v", err, ErrIsDir)
+	}
+
+	f, err := v.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Read(make([]byte, 1)); !errors.Is(err, ErrIsDir) {
+		t.Errorf("Read: got %v, want %v", err, ErrIsDir)
+	}
+}
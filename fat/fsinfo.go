@@ -0,0 +1,116 @@
+package fat
+
+// FSInfo signatures and the value used for unknown fields
+const (
+	FSInfoLeadSig  = 0x41615252
+	FSInfoStrucSig = 0x61417272
+	FSInfoTrailSig = 0xaa550000
+	FSInfoUnknown  = 0xffffffff
+)
+
+// Valid reports whether the three signatures of the sector are right
+func (f FSInfo) Valid() bool {
+	return f.LeadSig == FSInfoLeadSig && f.StrucSig == FSInfoStrucSig && f.TrailSig == FSInfoTrailSig
+}
+
+// fsInfoOffset returns where the FSInfo sector is stored, 0 if there's none
+func (v *Volume) fsInfoOffset() int64 {
+	if v.Info.Type != FAT32 || v.Ext32.FSInfo == 0 || v.Ext32.FSInfo == 0xffff {
+		return 0
+	}
+	return int64(v.Ext32.FSInfo) * int64(v.Info.SectorSize)
+}
+
+func (v *Volume) readFSInfo() error {
+	offset := v.fsInfoOffset()
+	if offset == 0 {
+		return nil
+	}
+	return v.readAt(offset, &v.FSInfo)
+}
+
+// writeFSInfo stores the free count and the next free hint. Volumes without a
+// valid FSInfo sector are left alone
+func (v *Volume) writeFSInfo() error {
+	if !v.FSInfo.Valid() || v.w == nil {
+		return nil
+	}
+
+	// free count and next free are the two words after the struct signature
+	return v.writeAt(v.fsInfoOffset()+488, [2]uint32{v.FSInfo.FreeCount, v.FSInfo.NextFree})
+}
+
+// nextFreeHint returns the cluster where the search for a free one starts
+func (v *Volume) nextFreeHint() uint32 {
+	if v.FSInfo.Valid() && v.validCluster(v.FSInfo.NextFree) {
+		return v.FSInfo.NextFree
+	}
+	return 2
+}
+
+// updateFSInfo is countFSInfo storing the sector right away
+func (v *Volume) updateFSInfo(delta int64, next uint32) error {
+	v.countFSInfo(delta, next)
+	return v.writeFSInfo()
+}
+
+// countFSInfo adds delta to the free count and keeps next as the next free
+// hint, if it's not 0. Unknown free counts stay unknown. Only the copy in
+// memory changes, writeFSInfo stores it
+func (v *Volume) countFSInfo(delta int64, next uint32) {
+	if !v.FSInfo.Valid() {
+		return
+	}
+
+	if count := v.FSInfo.FreeCount; count != FSInfoUnknown {
+		if c := int64(count) + delta; c >= 0 && c <= int64(v.Info.ClusterCount) {
+			v.FSInfo.FreeCount = uint32(c)
+		} else {
+			// the count was stale
+			v.FSInfo.FreeCount = FSInfoUnknown
+		}
+	}
+
+	if next != 0 {
+		v.FSInfo.NextFree = next
+	}
+}
+
+// FreeClusters counts the free clusters reading the whole FAT
+func (v *Volume) FreeClusters() (free uint32, err error) {
+	fat := v.newFATReader()
+
+	for i := uint32(2); i < v.Info.ClusterCount+2; i++ {
+		loc, err := fat.entry(i)
+		if err != nil {
+			return 0, err
+		}
+
+		if loc == 0 {
+			free++
+		}
+	}
+
+	return
+}
+
+// RecountFree recomputes the free count and the next free hint of the FSInfo
+// sector from the FAT. Use it when they are unknown or stale
+func (v *Volume) RecountFree() (err error) {
+	if !v.FSInfo.Valid() {
+		return ErrNoFSInfo
+	}
+
+	if v.FSInfo.FreeCount, err = v.FreeClusters(); err != nil {
+		return
+	}
+
+	if v.FSInfo.NextFree, err = v.findEmptyFAT(2); err == ErrNoSpace {
+		v.FSInfo.NextFree, err = FSInfoUnknown, nil
+	}
+	if err != nil {
+		return
+	}
+
+	return v.writeFSInfo()
+}
@@ -0,0 +1,113 @@
+package fat
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFSInfoUpdates(t *testing.T) {
+	v, _ := newImage(t, 40<<20, FormatOptions{FATBits: 32, SectorsPerCluster: 1})
+
+	free, err := v.FreeClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.FSInfo.FreeCount != free {
+		t.Fatalf("free count %d, FAT has %d free clusters", v.FSInfo.FreeCount, free)
+	}
+
+	content := bytes.Repeat([]byte{0xaa}, 10*int(v.Info.ClusterSize))
+	for _, name := range []string{"first", "second"} {
+		if err = v.WriteFile(name, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.FSInfo.FreeCount != free-20 {
+		t.Fatalf("free count %d after writing 20 clusters, want %d", v.FSInfo.FreeCount, free-20)
+	}
+
+	first, err := v.Lookup("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.FSInfo.NextFree < first.Location {
+		t.Fatalf("next free %d is below the clusters just allocated", v.FSInfo.NextFree)
+	}
+
+	// freeing clusters below the hint moves it back to them
+	if err = v.Remove("first", RemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if v.FSInfo.NextFree != first.Location {
+		t.Fatalf("next free %d after freeing cluster %d", v.FSInfo.NextFree, first.Location)
+	}
+	if v.FSInfo.FreeCount != free-10 {
+		t.Fatalf("free count %d after freeing 10 clusters, want %d", v.FSInfo.FreeCount, free-10)
+	}
+
+	if err = v.WriteFile("third", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if third, _ := v.Lookup("third"); third.Location != first.Location {
+		t.Fatalf("third file starts at %d, want the freed cluster %d", third.Location, first.Location)
+	}
+
+	if counted, _ := v.FreeClusters(); counted != v.FSInfo.FreeCount {
+		t.Fatalf("free count %d, FAT has %d free clusters", v.FSInfo.FreeCount, counted)
+	}
+}
+
+// fsInfoWrites counts the writes that land on the FSInfo sector
+type fsInfoWrites struct {
+	memImage
+	offset int64
+	writes int
+}
+
+func (w *fsInfoWrites) WriteAt(p []byte, off int64) (int, error) {
+	if off < w.offset+512 && off+int64(len(p)) > w.offset {
+		w.writes++
+	}
+	return w.memImage.WriteAt(p, off)
+}
+
+func TestFSInfoOncePerChain(t *testing.T) {
+	v, img := newImage(t, 40<<20, FormatOptions{FATBits: 32, SectorsPerCluster: 1})
+
+	w := &fsInfoWrites{memImage: img, offset: v.fsInfoOffset()}
+	v, err := New(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte{0xaa}, 50*int(v.Info.ClusterSize))
+	if err = v.WriteFile("file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("FSInfo written %d times for a 50 cluster file", w.writes)
+	}
+
+	e, err := v.Lookup("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := v.chain(e.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hint points past the clusters in use
+	if last := clusters[len(clusters)-1]; v.FSInfo.NextFree != last+1 {
+		t.Errorf("next free %d, last cluster allocated %d", v.FSInfo.NextFree, last)
+	}
+
+	// and it's what was stored
+	var stored FSInfo
+	if err = v.readAt(v.fsInfoOffset(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.NextFree != v.FSInfo.NextFree || stored.FreeCount != v.FSInfo.FreeCount {
+		t.Errorf("stored %d/%d, in memory %d/%d", stored.FreeCount, stored.NextFree, v.FSInfo.FreeCount, v.FSInfo.NextFree)
+	}
+}
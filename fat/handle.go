@@ -0,0 +1,319 @@
+package fat
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+)
+
+// File is an open regular file. Byte offsets are mapped to clusters walking
+// the cluster chain once, the chain is cached while the file is open
+type File struct {
+	v      *Volume
+	name   string
+	entry  EntryInfo
+	flag   int
+	chain  []uint32 // nil until the chain is walked
+	offset int64
+	closed bool
+}
+
+var (
+	_ io.ReadWriteSeeker = (*File)(nil)
+	_ io.ReaderAt        = (*File)(nil)
+	_ io.WriterAt        = (*File)(nil)
+	_ fs.File            = (*File)(nil)
+)
+
+// OpenFile opens the regular file at path. flag takes the same values as
+// os.OpenFile: O_RDONLY, O_WRONLY or O_RDWR, optionally together with
+// O_CREATE, O_EXCL, O_TRUNC and O_APPEND
+func (v *Volume) OpenFile(path string, flag int) (f *File, err error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 && v.w == nil {
+		return nil, ErrReadOnly
+	}
+
+	entry, err := v.lookup(splitPath(path))
+	switch {
+	case err == ErrNotFound && flag&os.O_CREATE != 0:
+		if err = v.WriteFile(path, bytes.NewReader(nil)); err != nil {
+			return
+		}
+		if entry, err = v.lookup(splitPath(path)); err != nil {
+			return
+		}
+	case err != nil:
+		return
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, ErrExists
+	}
+
+	if entry.Attr&AttrDir != 0 {
+		return nil, ErrIsDir
+	}
+
+	f = &File{v: v, name: path, entry: entry, flag: flag}
+
+	if flag&os.O_TRUNC != 0 && entry.Size != 0 {
+		if err = f.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Name returns the path the file was opened with
+func (f *File) Name() string {
+	return f.name
+}
+
+// Stat returns a fs.FileInfo describing the file
+func (f *File) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, f.pathError("stat", fs.ErrClosed)
+	}
+	return fileInfo{f.entry}, nil
+}
+
+// Read reads from the current offset
+func (f *File) Read(p []byte) (n int, err error) {
+	n, err = f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return
+}
+
+// ReadAt reads len(p) bytes starting at offset off. It returns io.EOF if
+// there are less bytes than that left
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if err = f.check("read", os.O_WRONLY); err != nil {
+		return
+	}
+
+	if off < 0 {
+		return 0, f.pathError("read", ErrBadOffset)
+	}
+
+	size := int64(f.entry.Size)
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	want := p[:min(int64(len(p)), size-off)]
+
+	if err = f.loadChain(); err != nil {
+		return 0, f.pathError("read", err)
+	}
+
+	err = f.clusters(want, off, func(offset int64, chunk []byte) error {
+		return f.v.readAt(offset, chunk)
+	})
+	if err != nil {
+		return 0, f.pathError("read", err)
+	}
+
+	if n = len(want); n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+// Write writes at the current offset or at the end of the file if it was
+// opened with O_APPEND
+func (f *File) Write(p []byte) (n int, err error) {
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(f.entry.Size)
+	}
+
+	n, err = f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+
+	return
+}
+
+// WriteAt writes p starting at offset off. Writing past the end of the file
+// allocates the clusters needed, any gap is filled with zeros
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if err = f.check("write", os.O_RDONLY); err != nil {
+		return
+	}
+
+	if off < 0 {
+		return 0, f.pathError("write", ErrBadOffset)
+	}
+
+	end := off + int64(len(p))
+	if end > math.MaxUint32 {
+		return 0, f.pathError("write", ErrFileTooBig)
+	}
+
+	if err = f.loadChain(); err != nil {
+		return 0, f.pathError("write", err)
+	}
+
+	if end > int64(f.entry.Size) {
+		if err = f.grow(end); err != nil {
+			return 0, f.pathError("write", err)
+		}
+	}
+
+	err = f.clusters(p, off, func(offset int64, chunk []byte) error {
+		return f.v.writeAt(offset, chunk)
+	})
+	if err != nil {
+		return 0, f.pathError("write", err)
+	}
+
+	if end > int64(f.entry.Size) {
+		f.entry.Size = uint32(end)
+	}
+
+	if err = f.v.touch(&f.entry); err != nil {
+		return 0, f.pathError("write", err)
+	}
+
+	return len(p), nil
+}
+
+// Seek sets the offset for the next Read or Write
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, f.pathError("seek", fs.ErrClosed)
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(f.entry.Size)
+	}
+
+	if offset < 0 {
+		return 0, f.pathError("seek", ErrBadOffset)
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Truncate changes the size of the file. The clusters past size are freed,
+// if the file grows it's filled with zeros
+func (f *File) Truncate(size int64) (err error) {
+	if err = f.check("truncate", os.O_RDONLY); err != nil {
+		return
+	}
+
+	if size < 0 || size > math.MaxUint32 {
+		return f.pathError("truncate", ErrBadOffset)
+	}
+
+	if err = f.loadChain(); err != nil {
+		return f.pathError("truncate", err)
+	}
+
+	if size > int64(f.entry.Size) {
+		err = f.grow(size)
+	} else {
+		keep := f.clusterCount(size)
+		if f.entry.Location, err = f.v.cutChain(f.entry.Location, f.chain, keep); err == nil {
+			f.chain = f.chain[:min(keep, len(f.chain))]
+		} else {
+			// the chain might be half cut, walk it again next time
+			f.chain = nil
+		}
+	}
+	if err != nil {
+		return f.pathError("truncate", err)
+	}
+
+	f.entry.Size = uint32(size)
+
+	if err = f.v.touch(&f.entry); err != nil {
+		return f.pathError("truncate", err)
+	}
+
+	return
+}
+
+// Close closes the file, every change was already written to the volume
+func (f *File) Close() error {
+	if f.closed {
+		return f.pathError("close", fs.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+// check returns an error if the file is closed or if it was opened with
+// the forbidden access mode
+func (f *File) check(op string, forbidden int) error {
+	switch {
+	case f.closed:
+		return f.pathError(op, fs.ErrClosed)
+	case f.flag&(os.O_WRONLY|os.O_RDWR) == forbidden:
+		return f.pathError(op, fs.ErrPermission)
+	}
+	return nil
+}
+
+func (f *File) pathError(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+// loadChain walks the cluster chain of the file if it wasn't done yet
+func (f *File) loadChain() (err error) {
+	if f.chain != nil || f.entry.Location == 0 {
+		return nil
+	}
+
+	f.chain, err = f.v.chain(f.entry.Location)
+
+	return
+}
+
+// clusterCount returns how many clusters hold size bytes
+func (f *File) clusterCount(size int64) int {
+	cs := int64(f.v.Info.ClusterSize)
+	return int((size + cs - 1) / cs)
+}
+
+// grow extends the file to size bytes allocating zeroed clusters
+func (f *File) grow(size int64) (err error) {
+	f.entry.Location, f.chain, err = f.v.growChain(f.entry.Location, f.chain, f.entry.Size, f.clusterCount(size))
+	return
+}
+
+// clusters splits p, which starts at offset off of the file, in the pieces
+// stored inside each cluster and calls do with the volume offset of every one
+func (f *File) clusters(p []byte, off int64, do func(offset int64, chunk []byte) error) error {
+	cs := int64(f.v.Info.ClusterSize)
+
+	for done := 0; done < len(p); {
+		pos := off + int64(done)
+
+		i := pos / cs
+		if i >= int64(len(f.chain)) {
+			return ErrBadChain
+		}
+
+		within := pos % cs
+		chunk := p[done:min(len(p), done+int(cs-within))]
+
+		if err := do(int64(getFileOffset(f.chain[i], f.v.BPB, f.v.Info))+within, chunk); err != nil {
+			return err
+		}
+
+		done += len(chunk)
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+)
+
+// writeModel is what WriteAt does to the content of a file
+func writeModel(content []byte, p []byte, off int) []byte {
+	if end := off + len(p); end > len(content) {
+		content = append(content, make([]byte, end-len(content))...)
+	}
+	copy(content[off:], p)
+	return content
+}
+
+func TestFileReadWriteAt(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			cs := int(v.Info.ClusterSize)
+			var content []byte
+
+			// across cluster boundaries, past the end leaving a gap and
+			// over what's already there
+			for i, w := range []struct {
+				off, size int
+			}{
+				{0, cs + 7},
+				{cs - 3, 10},
+				{3*cs + 5, 2*cs + 1},
+				{cs / 2, 3 * cs},
+				{0, 1},
+			} {
+				p := bytes.Repeat([]byte{byte(i + 1)}, w.size)
+				if n, err := f.WriteAt(p, int64(w.off)); err != nil || n != len(p) {
+					t.Fatalf("write %d: %d bytes, %v", i, n, err)
+				}
+				content = writeModel(content, p, w.off)
+			}
+
+			for _, r := range []struct {
+				off, size int
+			}{{0, len(content)}, {cs - 5, 12}, {2*cs + 1, cs}, {len(content) - 1, 1}} {
+				p := make([]byte, r.size)
+				if n, err := f.ReadAt(p, int64(r.off)); err != nil || n != r.size {
+					t.Fatalf("read at %d: %d bytes, %v", r.off, n, err)
+				}
+				if !bytes.Equal(p, content[r.off:r.off+r.size]) {
+					t.Fatalf("read at %d: content differs", r.off)
+				}
+			}
+
+			// short reads at the end
+			p := make([]byte, 10)
+			if n, err := f.ReadAt(p, int64(len(content)-4)); err != io.EOF || n != 4 {
+				t.Fatalf("read at the end: %d bytes, %v", n, err)
+			}
+			if n, err := f.ReadAt(p, int64(len(content)+1)); err != io.EOF || n != 0 {
+				t.Fatalf("read past the end: %d bytes, %v", n, err)
+			}
+			if _, err := f.ReadAt(p, -1); !errors.Is(err, ErrBadOffset) {
+				t.Fatalf("got %v, want %v", err, ErrBadOffset)
+			}
+
+			checkFile(t, v, "file", content)
+		})
+	}
+}
+
+func TestFileSeek(t *testing.T) {
+	v, _ := newImage(t, 1440<<10, FormatOptions{})
+
+	f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		offset int64
+		whence int
+		want   int64
+		read   string
+	}{
+		{2, io.SeekStart, 2, "23"},
+		{3, io.SeekCurrent, 7, "78"},
+		{-4, io.SeekCurrent, 5, "56"},
+		{-3, io.SeekEnd, 7, "78"},
+		{0, io.SeekEnd, 10, ""},
+		{5, io.SeekEnd, 15, ""},
+	} {
+		got, err := f.Seek(c.offset, c.whence)
+		if err != nil || got != c.want {
+			t.Fatalf("seek %d from %d: got %d, %v, want %d", c.offset, c.whence, got, err, c.want)
+		}
+
+		p := make([]byte, 2)
+		n, _ := f.Read(p)
+		if string(p[:n]) != c.read {
+			t.Fatalf("seek %d from %d: read %q, want %q", c.offset, c.whence, p[:n], c.read)
+		}
+	}
+
+	// writing past the end after a seek leaves a gap of zeros
+	if _, err = f.Seek(12, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, v, "file", []byte("0123456789\x00\x00x"))
+
+	for _, whence := range []int{io.SeekStart, io.SeekCurrent, io.SeekEnd} {
+		if _, err = f.Seek(-100, whence); !errors.Is(err, ErrBadOffset) {
+			t.Fatalf("whence %d: got %v, want %v", whence, err, ErrBadOffset)
+		}
+	}
+}
+
+func TestFileTruncate(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := v.OpenFile("file", os.O_RDWR|os.O_CREATE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			cs := int(v.Info.ClusterSize)
+			content := bytes.Repeat([]byte{0xaa}, 2*cs+10)
+			if _, err = f.Write(content); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, size := range []int{cs + 1, 4*cs + 3, cs, 0, cs / 2} {
+				if err = f.Truncate(int64(size)); err != nil {
+					t.Fatal(err)
+				}
+				if size < len(content) {
+					content = content[:size]
+				} else {
+					content = append(content, make([]byte, size-len(content))...)
+				}
+				checkFile(t, v, "file", content)
+				checkFree(t, v, free-uint32((size+cs-1)/cs))
+			}
+
+			if err = f.Truncate(-1); !errors.Is(err, ErrBadOffset) {
+				t.Fatalf("got %v, want %v", err, ErrBadOffset)
+			}
+		})
+	}
+}
+
+func TestOpenFileFlags(t *testing.T) {
+	v, _ := newImage(t, 1440<<10, FormatOptions{})
+
+	free, err := v.FreeClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(flag int) *File {
+		t.Helper()
+		f, err := v.OpenFile("file", flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	if _, err = v.OpenFile("file", os.O_RDONLY); err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+
+	f := open(os.O_WRONLY | os.O_CREATE | os.O_EXCL)
+	if _, err = f.Write(bytes.Repeat([]byte("a"), 3000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Read(make([]byte, 1)); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("read: got %v, want %v", err, fs.ErrPermission)
+	}
+
+	if _, err = v.OpenFile("file", os.O_RDWR|os.O_CREATE|os.O_EXCL); err != ErrExists {
+		t.Fatalf("got %v, want %v", err, ErrExists)
+	}
+
+	// appends ignore the offset
+	f = open(os.O_RDWR | os.O_APPEND)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("bc")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, v, "file", append(bytes.Repeat([]byte("a"), 3000), "bc"...))
+
+	f = open(os.O_RDONLY)
+	if _, err = f.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("write: got %v, want %v", err, fs.ErrPermission)
+	}
+
+	// O_TRUNC frees the clusters
+	f = open(os.O_WRONLY | os.O_TRUNC)
+	if info, _ := f.Stat(); info.Size() != 0 {
+		t.Fatalf("size %d after O_TRUNC", info.Size())
+	}
+	checkFile(t, v, "file", nil)
+	checkFree(t, v, free)
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("got %v, want %v", err, fs.ErrClosed)
+	}
+}
+
+func TestFileChainCache(t *testing.T) {
+	v, _ := newImage(t, 16<<20, FormatOptions{})
+
+	cs := int(v.Info.ClusterSize)
+	content := bytes.Repeat([]byte("cache"), cs)
+	if err := v.WriteFile("file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := v.OpenFile("file", os.O_RDWR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := make([]byte, len(content))
+	if _, err = f.ReadAt(p, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain is cut on disk behind the back of the open file, it keeps
+	// reading through the chain walked before
+	chain, err := v.chain(f.entry.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(f.chain, chain) {
+		t.Fatalf("cached %v, want %v", f.chain, chain)
+	}
+	if err = v.setFATEntry(chain[1], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.ReadAt(p, 0); err != nil || !bytes.Equal(p, content) {
+		t.Fatalf("cached read: %v", err)
+	}
+	if _, err = v.ReadFile("file"); err == nil {
+		t.Fatal("read through the cut chain")
+	}
+	if err = v.setFATEntry(chain[1], chain[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache follows the chain when it grows and shrinks
+	for _, size := range []int64{int64(len(content)) + 3*int64(cs), int64(cs) + 1, 0, 10} {
+		if err = f.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+
+		var want []uint32
+		if f.entry.Location != 0 {
+			if want, err = v.chain(f.entry.Location); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !slices.Equal(f.chain, want) {
+			t.Fatalf("size %d: cached %v, want %v", size, f.chain, want)
+		}
+	}
+
+	checkClean(t, v, "file")
+}
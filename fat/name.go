@@ -0,0 +1,326 @@
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// bytes allowed in a short name besides the ones above 0x7f
+var validChars = map[byte]struct{}{
+	33:  {},
+	35:  {},
+	36:  {},
+	37:  {},
+	38:  {},
+	39:  {},
+	40:  {},
+	41:  {},
+	45:  {},
+	46:  {},
+	48:  {},
+	49:  {},
+	50:  {},
+	51:  {},
+	52:  {},
+	53:  {},
+	54:  {},
+	55:  {},
+	56:  {},
+	57:  {},
+	64:  {},
+	65:  {},
+	66:  {},
+	67:  {},
+	68:  {},
+	69:  {},
+	70:  {},
+	71:  {},
+	72:  {},
+	73:  {},
+	74:  {},
+	75:  {},
+	76:  {},
+	77:  {},
+	78:  {},
+	79:  {},
+	80:  {},
+	81:  {},
+	82:  {},
+	83:  {},
+	84:  {},
+	85:  {},
+	86:  {},
+	87:  {},
+	88:  {},
+	89:  {},
+	90:  {},
+	94:  {},
+	95:  {},
+	96:  {},
+	97:  {},
+	98:  {},
+	99:  {},
+	100: {},
+	101: {},
+	102: {},
+	103: {},
+	104: {},
+	105: {},
+	106: {},
+	107: {},
+	108: {},
+	109: {},
+	110: {},
+	111: {},
+	112: {},
+	113: {},
+	114: {},
+	115: {},
+	116: {},
+	117: {},
+	118: {},
+	119: {},
+	120: {},
+	121: {},
+	122: {},
+	123: {},
+	125: {},
+	126: {},
+}
+
+// basisName builds the primary and extension parts of the short name of name
+// the way Windows does: it's upper-cased, spaces and every period but the
+// last one are dropped, characters not allowed in a short name or missing
+// from the code page become '_' and both parts are truncated to 8 and 3
+// bytes. exact tells if the result is the same name besides the case, if not
+// it needs a numeric tail
+func basisName(name string, cp CodePage) (primary, ext string, exact bool, err error) {
+	if name == "" {
+		return "", "", false, errors.New("name should at least have one character")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		switch {
+		case r == ' ':
+		case r == '.':
+			b.WriteRune('.')
+		case r < 0x80:
+			if _, ok := validChars[byte(r)]; ok {
+				b.WriteRune(r)
+				break
+			}
+			fallthrough
+		default:
+			if _, ok := cp.encodeRune(r); ok && r >= 0x80 {
+				b.WriteRune(r)
+			} else {
+				b.WriteRune('_')
+			}
+		}
+	}
+
+	// leading periods are dropped, the last one left splits the extension
+	stripped := strings.TrimLeft(b.String(), ".")
+	if i := strings.LastIndexByte(stripped, '.'); i >= 0 {
+		primary, ext = strings.ReplaceAll(stripped[:i], ".", ""), stripped[i+1:]
+	} else {
+		primary = stripped
+	}
+
+	if primary == "" {
+		return "", "", false, fmt.Errorf("no short name can be made out of %q", name)
+	}
+
+	primary, ext = cp.cut(primary, 8), cp.cut(ext, 3)
+
+	exact = strings.ToUpper(name) == joinShort(primary, ext)
+
+	return
+}
+
+// caseFlags returns the NTRes flags that turn the short name short back into
+// name. ok is false when name needs a long name: short isn't its basis name
+// or the base name or the extension mix upper and lowercase
+func caseFlags(name string, short Str11Byte, cp CodePage) (flags uint8, ok bool) {
+	primary, ext, exact, err := basisName(name, cp)
+	if err != nil || !exact || packShort(primary, ext, cp) != short {
+		return 0, false
+	}
+
+	// exact means name has at most one period and it's not the first
+	// character
+	base, nameExt, _ := strings.Cut(name, ".")
+
+	for _, part := range []struct {
+		s    string
+		flag uint8
+	}{{base, NTResLowerBase}, {nameExt, NTResLowerExt}} {
+		upper := strings.ToUpper(part.s)
+		switch part.s {
+		case upper:
+		case strings.ToLower(upper):
+			flags |= part.flag
+		default:
+			return 0, false
+		}
+	}
+
+	return flags, true
+}
+
+// joinShort puts together both parts of a short name as NAME.EXT
+func joinShort(primary, ext string) string {
+	if ext == "" {
+		return primary
+	}
+	return primary + "." + ext
+}
+
+// withTail puts the numeric tail ~n at the end of primary, which is cut to
+// keep the name within 8 bytes
+func withTail(primary string, n int, cp CodePage) string {
+	tail := "~" + strconv.Itoa(n)
+	return cp.cut(primary, 8-len(tail)) + tail
+}
+
+// withHashTail is the tail Windows uses once ~1 to ~4 are taken: the first
+// two bytes of primary, a hash of the long name in hex and ~n
+func withHashTail(primary, name string, n int, cp CodePage) string {
+	return withTail(fmt.Sprintf("%s%04X", cp.cut(primary, 2), shortNameHash(name)), n, cp)
+}
+
+// shortNameHash is the hash of the long name used by withHashTail, as
+// Windows computes it
+func shortNameHash(name string) uint16 {
+	var sum uint16
+	for _, c := range utf16.Encode([]rune(name)) {
+		sum = sum*0x25 + c
+	}
+
+	temp := int32(uint32(sum) * 314159269)
+	if temp < 0 {
+		temp = -temp
+	}
+	temp -= int32(uint64(int64(temp)*1152921497)>>60) * 1000000007
+	sum = uint16(temp)
+
+	// the nibbles end up reversed
+	return sum>>12 | sum>>4&0x00f0 | sum<<4&0x0f00 | sum<<12
+}
+
+// packShort encodes primary and ext into the 11 byte short name padded with
+// spaces. A leading 0xe5 is stored as 0x05 so it's not taken as deleted
+func packShort(primary, ext string, cp CodePage) (short Str11Byte) {
+	copy(short[:], "           ")
+	copy(short[:8], cp.encode(primary))
+	copy(short[8:], cp.encode(ext))
+
+	if short[0] == NameDeleted {
+		short[0] = NameKanji
+	}
+
+	return short
+}
+
+// characters of a long filename stored in every long entry
+const longNameChunk = 13
+
+// longest long filename in UTF-16 code units
+const maxLongName = 255
+
+// characters that cannot be used in a long filename besides control codes
+const invalidLongChars = `"*/:<>?\|`
+
+// convNameLong converts name into the long entries that precede the short
+// entry shortName. The name is stored as UTF-16LE, if it doesn't fill the
+// last entry it's terminated with 0x0000 and padded with 0xffff. Entries are
+// returned in the order they are written to disk (highest ordinal first)
+func convNameLong(name, shortName string) (entries []DirEntryLong, err error) {
+	chksm, err := checksum([]byte(shortName))
+	if err != nil {
+		return []DirEntryLong{}, err
+	}
+
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(invalidLongChars, r) {
+			return []DirEntryLong{}, fmt.Errorf("invalid character %q in long name", r)
+		}
+	}
+
+	units := utf16.Encode([]rune(name))
+
+	if len(units) == 0 {
+		return []DirEntryLong{}, errors.New("name should at least have one character")
+	}
+	if len(units) > maxLongName {
+		return []DirEntryLong{}, errors.New("long name too long")
+	}
+
+	if len(units)%longNameChunk != 0 {
+		units = append(units, 0x0000)
+	}
+	for len(units)%longNameChunk != 0 {
+		units = append(units, 0xffff)
+	}
+
+	nparts := len(units) / longNameChunk
+	entries = make([]DirEntryLong, nparts)
+
+	for i := 0; i < nparts; i++ {
+		j := nparts - i - 1 // reverse index
+
+		longNameInsert(&entries[j], units[i*longNameChunk:(i+1)*longNameChunk])
+
+		entries[j].Attr = AttrLongName
+		entries[j].Checksum = chksm
+		entries[j].Ordinal = HexByte(i + 1)
+	}
+
+	entries[0].Ordinal |= LastEntryLong
+
+	return entries, nil
+}
+
+// longNameInsert stores 13 UTF-16 code units into the name fields of entry
+func longNameInsert(entry *DirEntryLong, part []uint16) {
+	for i, c := range part {
+		switch {
+		case i >= 0 && i <= 4:
+			binary.LittleEndian.PutUint16(entry.Name1[i*2:], c)
+		case i >= 5 && i <= 10:
+			binary.LittleEndian.PutUint16(entry.Name2[(i-5)*2:], c)
+		case i >= 11 && i <= 12:
+			binary.LittleEndian.PutUint16(entry.Name3[(i-11)*2:], c)
+		}
+	}
+}
+
+// longNameParts returns the 13 UTF-16 code units stored inside entry
+func longNameParts(entry DirEntryLong) (part []uint16) {
+	part = make([]uint16, 0, longNameChunk)
+
+	for _, name := range [][]byte{entry.Name1[:], entry.Name2[:], entry.Name3[:]} {
+		for i := 0; i < len(name); i += 2 {
+			part = append(part, binary.LittleEndian.Uint16(name[i:]))
+		}
+	}
+
+	return
+}
+
+func checksum(shortName []byte) (sum uint8, err error) {
+	if len(shortName) != 11 {
+		return 0, errors.New("short name too long")
+	}
+
+	for _, c := range shortName {
+		sum = 0x80*(sum&1) + (sum >> 1) + c
+	}
+
+	return sum, nil
+}
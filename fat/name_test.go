@@ -0,0 +1,258 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// vfatSlots lays out the long entries of name the way Linux vfat writes them:
+// UTF-16LE split in 13 unit chunks, a 0x0000 terminator and 0xffff padding
+// only when the last chunk isn't full, highest ordinal first
+func vfatSlots(name, short string) []byte {
+	units := utf16.Encode([]rune(name))
+	if len(units)%13 != 0 {
+		units = append(units, 0x0000)
+		for len(units)%13 != 0 {
+			units = append(units, 0xffff)
+		}
+	}
+
+	var sum uint8
+	for _, c := range []byte(short) {
+		sum = (sum>>1 | sum<<7) + c
+	}
+
+	// where every one of the 13 units goes inside the slot
+	positions := []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30}
+
+	var slots []byte
+	for n := len(units) / 13; n >= 1; n-- {
+		slot := make([]byte, RootEntrySize)
+		slot[0] = byte(n)
+		if n == len(units)/13 {
+			slot[0] |= 0x40
+		}
+		slot[11] = 0x0f
+		slot[13] = sum
+		for i, u := range units[(n-1)*13 : n*13] {
+			binary.LittleEndian.PutUint16(slot[positions[i]:], u)
+		}
+		slots = append(slots, slot...)
+	}
+
+	return slots
+}
+
+func TestLongNameRoundTrip(t *testing.T) {
+	const short = "LONGNA~1TXT"
+
+	names := []string{
+		"a",
+		"hello world.txt",
+		"Ünïcode ñame.txt",
+		"文件名.文档",
+		"emoji 😀 inside",
+		"𝄞𝄞𝄞𝄞𝄞𝄞𝄞",                   // 14 units, a surrogate pair split between slots
+		"exactly13char",             // 13 units, no terminator
+		"twenty five characters xx", // 25 units
+		"exactly 26 characters wxyz",
+		"𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞𝄞", // 26 units made of surrogate pairs
+		strings.Repeat("x", maxLongName),
+	}
+
+	for _, name := range names {
+		entries, err := convNameLong(name, short)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+
+		var got bytes.Buffer
+		if err = binary.Write(&got, binary.LittleEndian, entries); err != nil {
+			t.Fatal(err)
+		}
+		if want := vfatSlots(name, short); !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%q: slots differ\ngot  % x\nwant % x", name, got.Bytes(), want)
+			continue
+		}
+
+		parts := make([][]uint16, 0, len(entries))
+		for _, e := range entries {
+			parts = append(parts, longNameParts(e))
+		}
+		if decoded := buildLongFilename(parts); decoded != name {
+			t.Errorf("%q: decoded as %q", name, decoded)
+		}
+	}
+}
+
+func TestLongNameExactChunks(t *testing.T) {
+	for _, name := range []string{"exactly13char", "exactly 26 characters wxyz"} {
+		entries, err := convNameLong(name, "EXACTL~1   ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries)*longNameChunk != len(name) {
+			t.Fatalf("%q: %d entries", name, len(entries))
+		}
+
+		// the first slot on disk holds the end of the name, no 0x0000 and
+		// no 0xffff padding there
+		last := longNameParts(entries[0])
+		if last[longNameChunk-1] != uint16(name[len(name)-1]) {
+			t.Errorf("%q: last unit is %#x", name, last[longNameChunk-1])
+		}
+	}
+}
+
+func TestLongNameInvalid(t *testing.T) {
+	for _, name := range []string{"", "a/b", "what?", "tab\there", strings.Repeat("x", maxLongName+1)} {
+		if _, err := convNameLong(name, "ABCDEF~1TXT"); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+}
+
+func TestLongNameVolume(t *testing.T) {
+	v, _ := newImage(t, 1440<<10, FormatOptions{})
+
+	names := []string{"exactly13char", "exactly 26 characters wxyz", "𝄞𝄞𝄞𝄞𝄞𝄞𝄞", "Ünïcode 文件.txt"}
+	for _, name := range names {
+		if err := v.WriteFile(name, strings.NewReader(name)); err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+	}
+
+	entries, err := v.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[string]bool{}
+	for _, e := range entries {
+		stored[e.LongName] = true
+	}
+	for _, name := range names {
+		if !stored[name] {
+			t.Errorf("%q not found", name)
+		}
+	}
+}
+
+func TestShortNameTails(t *testing.T) {
+	v, img := newImage(t, 16<<20, FormatOptions{})
+
+	name := func(i int) string { return fmt.Sprintf("long file name %d.txt", i) }
+	hashed := func(i, n int) string { return fmt.Sprintf("LO%04X~%d.TXT", shortNameHash(name(i)), n) }
+
+	// ~1 to ~4, then a hash of the long name
+	want := []string{"LONGFI~1.TXT", "LONGFI~2.TXT", "LONGFI~3.TXT", "LONGFI~4.TXT"}
+	for i := 5; i <= 8; i++ {
+		want = append(want, hashed(i, 1))
+	}
+
+	for i := 1; i <= 8; i++ {
+		if err := v.WriteFile(name(i), strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every hashed tail of the next name is taken, ~5 comes next
+	var taken []byte
+	for n := 1; n <= 9; n++ {
+		short := strings.TrimSuffix(hashed(9, n), ".TXT")
+		taken = append(taken, shortSlot(fmt.Sprintf("%-8sTXT", short))...)
+	}
+	// right after the 8 files, two long slots and a short one each
+	putRaw(t, v, img, 0, 8*3, taken)
+
+	for i := 9; i <= 10; i++ {
+		if err := v.WriteFile(name(i), strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = append(want, "LONGFI~5.TXT", hashed(10, 1))
+
+	seen := map[string]bool{}
+	for i, w := range want {
+		e, err := v.Lookup(name(i + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.ShortName != w {
+			t.Errorf("%q: short name %s, want %s", name(i+1), e.ShortName, w)
+		}
+		if seen[e.ShortName] {
+			t.Errorf("%s used twice", e.ShortName)
+		}
+		seen[e.ShortName] = true
+	}
+
+	// longer tails cut the basis name further
+	for n, w := range map[int]string{5: "LONGFI~5", 10: "LONGF~10", 999999: "L~999999"} {
+		if got := withTail("LONGFILENAME", n, v.CodePage); got != w {
+			t.Errorf("tail %d: got %s, want %s", n, got, w)
+		}
+	}
+}
+
+func TestShortNameCase(t *testing.T) {
+	v, img := newImage(t, 1440<<10, FormatOptions{})
+
+	cases := []struct {
+		name  string
+		ntres uint8
+		long  bool
+	}{
+		{"readme.TXT", NTResLowerBase, false},
+		{"INSTALL.txt", NTResLowerExt, false},
+		{"notes.txt", NTResLowerBase | NTResLowerExt, false},
+		{"MAKEFILE", 0, false},
+		{"MixedCase.txt", 0, true}, // mixed case needs a long name
+	}
+
+	for _, c := range cases {
+		if err := v.WriteFile(c.name, strings.NewReader(c.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range cases {
+		e, err := v.Lookup(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Entry.NTRes != c.ntres {
+			t.Errorf("%q: NTRes %#x, want %#x", c.name, e.Entry.NTRes, c.ntres)
+		}
+		if long := e.nlong != 0; long != c.long {
+			t.Errorf("%q: %d long slots", c.name, e.nlong)
+		}
+		if e.Name() != c.name {
+			t.Errorf("%q: read back as %q", c.name, e.Name())
+		}
+
+		// the name on disk is upper case
+		d, err := v.openDir(e.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := d.slotOffset(e.slot)
+		if stored := string(img[offset : offset+11]); stored != strings.ToUpper(stored) {
+			t.Errorf("%q: stored as %q", c.name, stored)
+		}
+	}
+
+	names := listNames(t, v, "")
+	for _, c := range cases {
+		if !slices.Contains(names, c.name) {
+			t.Errorf("%q not listed in %q", c.name, names)
+		}
+	}
+}
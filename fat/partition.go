@@ -0,0 +1,409 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Partition is an entry of the MBR or GPT partition table of a whole disk image
+type Partition struct {
+	Index  int    // 1-4 for MBR primary partitions, 5 and up for logical ones. GPT entry number
+	Scheme string // "mbr" or "gpt"
+	Type   string // MBR type byte as 0xNN or GPT type GUID
+	Name   string // GPT partition name
+	GUID   string // GPT unique partition GUID
+	Offset int64  // in bytes from the start of the disk
+	Size   int64  // in bytes
+}
+
+// TypeName returns a human name for the partition type if it's a known one
+func (p Partition) TypeName() string {
+	if name, ok := partitionTypeNames[p.Type]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+var partitionTypeNames = map[string]string{
+	"0x01": "FAT12",
+	"0x04": "FAT16 <32M",
+	"0x05": "extended",
+	"0x06": "FAT16",
+	"0x07": "NTFS/exFAT",
+	"0x0b": "FAT32",
+	"0x0c": "FAT32 LBA",
+	"0x0e": "FAT16 LBA",
+	"0x0f": "extended LBA",
+	"0x82": "linux swap",
+	"0x83": "linux",
+	"0x85": "linux extended",
+	"0xee": "GPT protective",
+	"0xef": "EFI system",
+
+	"C12A7328-F81F-11D2-BA4B-00A0C93EC93B": "EFI system",
+	"EBD0A0A2-B9E5-4433-87C0-68B6B72699C7": "basic data",
+	"0FC63DAF-8483-4772-8E79-3D69D8477DE4": "linux filesystem",
+	"0657FD6D-A4AB-43C4-84E5-0933C84B4F4F": "linux swap",
+	"E3C9E316-0B5C-4DB8-817D-F92DF00215AE": "microsoft reserved",
+}
+
+// mbrEntry is one of the four partition entries of a MBR or EBR
+type mbrEntry struct {
+	Status   uint8
+	CHSFirst [3]uint8
+	Type     uint8
+	CHSLast  [3]uint8
+	LBAFirst uint32
+	Sectors  uint32
+}
+
+// the partition table starts at byte 446 of the MBR
+const mbrTableOffset = 446
+
+// MBR addresses are always given in 512 byte sectors
+const mbrSectorSize = 512
+
+// longest chain of EBRs followed before giving up
+const maxLogicalPartitions = 128
+
+type gptHeader struct {
+	Signature      [8]uint8
+	Revision       uint32
+	HeaderSize     uint32
+	HeaderCRC32    uint32
+	Reserved       uint32
+	MyLBA          uint64
+	AlternateLBA   uint64
+	FirstUsableLBA uint64
+	LastUsableLBA  uint64
+	DiskGUID       [16]uint8
+	EntryLBA       uint64
+	EntryCount     uint32
+	EntrySize      uint32
+	EntryCRC32     uint32
+}
+
+type gptEntry struct {
+	TypeGUID   [16]uint8
+	UniqueGUID [16]uint8
+	FirstLBA   uint64
+	LastLBA    uint64
+	Attributes uint64
+	Name       [36]uint16
+}
+
+const gptSignature = "EFI PART"
+
+// sizes of gptHeader and gptEntry as stored on disk
+const (
+	gptHeaderSize = 92
+	gptEntrySize  = 128
+)
+
+// Partitions reads the partition table of a whole disk image. A GPT is used
+// if the MBR holds a protective partition
+func Partitions(r io.ReaderAt) (parts []Partition, err error) {
+	sector := make([]byte, mbrSectorSize)
+	if _, err = r.ReadAt(sector, 0); err != nil {
+		return nil, err
+	}
+
+	if sector[510] != 0x55 || sector[511] != 0xaa {
+		return nil, ErrNoPartitions
+	}
+
+	// a FAT boot sector also ends with 0x55aa
+	if _, _, _, _, e := readReservedSector(bytes.NewReader(sector)); e == nil {
+		return nil, ErrNoPartitions
+	}
+
+	var entries [4]mbrEntry
+	if _, err = binary.Decode(sector[mbrTableOffset:], binary.LittleEndian, &entries); err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.Status&0x7f != 0 {
+			// not a valid status so it isn't a partition table
+			return nil, ErrNoPartitions
+		}
+		if e.Type == 0xee {
+			return gptPartitions(r)
+		}
+	}
+
+	for i, e := range entries {
+		if e.Type == 0 || e.Sectors == 0 {
+			continue
+		}
+
+		parts = append(parts, mbrPartition(i+1, e, 0))
+
+		if isExtended(e.Type) {
+			logical, err := logicalPartitions(r, int64(e.LBAFirst))
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, logical...)
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrNoPartitions
+	}
+
+	return
+}
+
+func isExtended(t uint8) bool {
+	return t == 0x05 || t == 0x0f || t == 0x85
+}
+
+func mbrPartition(index int, e mbrEntry, base int64) Partition {
+	return Partition{
+		Index:  index,
+		Scheme: "mbr",
+		Type:   fmt.Sprintf("0x%02x", e.Type),
+		Offset: (base + int64(e.LBAFirst)) * mbrSectorSize,
+		Size:   int64(e.Sectors) * mbrSectorSize,
+	}
+}
+
+// logicalPartitions follows the chain of EBRs inside the extended partition
+// starting at sector ext. The first entry of each EBR is a logical partition
+// relative to the EBR and the second one points to the next EBR relative to
+// the extended partition
+func logicalPartitions(r io.ReaderAt, ext int64) (parts []Partition, err error) {
+	sector := make([]byte, mbrSectorSize)
+
+	for ebr, n := ext, 0; ; n++ {
+		if n == maxLogicalPartitions {
+			return nil, errors.New("too many logical partitions, the EBR chain probably loops")
+		}
+
+		if _, err = r.ReadAt(sector, ebr*mbrSectorSize); err != nil {
+			return
+		}
+		if sector[510] != 0x55 || sector[511] != 0xaa {
+			return nil, fmt.Errorf("bad EBR signature at sector %d", ebr)
+		}
+
+		var entries [2]mbrEntry
+		if _, err = binary.Decode(sector[mbrTableOffset:], binary.LittleEndian, &entries); err != nil {
+			return
+		}
+
+		if entries[0].Type != 0 && entries[0].Sectors != 0 {
+			// logical partitions are numbered from 5 like linux does
+			parts = append(parts, mbrPartition(5+len(parts), entries[0], ebr))
+		}
+
+		if !isExtended(entries[1].Type) || entries[1].LBAFirst == 0 {
+			return
+		}
+		ebr = ext + int64(entries[1].LBAFirst)
+	}
+}
+
+// gptPartitions reads the GPT. The backup header at the end of the disk is
+// used when the primary one is corrupted and the disk size is known
+func gptPartitions(r io.ReaderAt) (parts []Partition, err error) {
+	// the header sits on the second sector, whatever its size is
+	for _, ss := range []int64{512, 4096} {
+		header, err := readGPTHeader(r, ss, 1)
+		if err == ErrNoPartitions {
+			continue
+		}
+
+		if err == nil {
+			if parts, err = readGPTEntries(r, ss, header); err == nil {
+				return parts, nil
+			}
+		}
+
+		if size := diskSize(r); size > 0 {
+			if backup, e := readGPTHeader(r, ss, uint64(size/ss-1)); e == nil {
+				if parts, e = readGPTEntries(r, ss, backup); e == nil {
+					return parts, nil
+				}
+			}
+		}
+
+		return nil, err
+	}
+
+	return nil, ErrNoPartitions
+}
+
+// readGPTHeader reads and validates the GPT header at sector lba
+func readGPTHeader(r io.ReaderAt, ss int64, lba uint64) (header gptHeader, err error) {
+	raw := make([]byte, ss)
+	if _, err = r.ReadAt(raw, int64(lba)*ss); err != nil {
+		return
+	}
+
+	if _, err = binary.Decode(raw, binary.LittleEndian, &header); err != nil {
+		return
+	}
+
+	if string(header.Signature[:]) != gptSignature {
+		return header, ErrNoPartitions
+	}
+	if header.HeaderSize < gptHeaderSize || int64(header.HeaderSize) > ss || header.MyLBA != lba {
+		return header, ErrBadGPT
+	}
+
+	// the CRC is computed with its own field set to zero
+	binary.LittleEndian.PutUint32(raw[16:], 0)
+	if crc32.ChecksumIEEE(raw[:header.HeaderSize]) != header.HeaderCRC32 {
+		return header, fmt.Errorf("%w: bad header CRC", ErrBadGPT)
+	}
+
+	// entries are 128 bytes times a power of two, none is bigger than a sector
+	if header.EntrySize < gptEntrySize || header.EntrySize%gptEntrySize != 0 || int64(header.EntrySize) > ss ||
+		header.EntryCount > 1024 {
+		return header, ErrBadGPT
+	}
+
+	return
+}
+
+func readGPTEntries(r io.ReaderAt, ss int64, header gptHeader) (parts []Partition, err error) {
+	raw := make([]byte, int64(header.EntryCount)*int64(header.EntrySize))
+	if _, err = r.ReadAt(raw, int64(header.EntryLBA)*ss); err != nil {
+		return
+	}
+
+	if crc32.ChecksumIEEE(raw) != header.EntryCRC32 {
+		return nil, fmt.Errorf("%w: bad partition entries CRC", ErrBadGPT)
+	}
+
+	for i := 0; i < int(header.EntryCount); i++ {
+		var e gptEntry
+		if _, err = binary.Decode(raw[i*int(header.EntrySize):], binary.LittleEndian, &e); err != nil {
+			return
+		}
+
+		if e.TypeGUID == [16]uint8{} {
+			continue
+		}
+		if e.LastLBA < e.FirstLBA {
+			return nil, fmt.Errorf("%w: partition %d ends before it starts", ErrBadGPT, i+1)
+		}
+
+		name := utf16.Decode(e.Name[:])
+		for j, c := range name {
+			if c == 0 {
+				name = name[:j]
+				break
+			}
+		}
+
+		parts = append(parts, Partition{
+			Index:  i + 1,
+			Scheme: "gpt",
+			Type:   guidString(e.TypeGUID),
+			Name:   string(name),
+			GUID:   guidString(e.UniqueGUID),
+			Offset: int64(e.FirstLBA) * ss,
+			Size:   int64(e.LastLBA-e.FirstLBA+1) * ss,
+		})
+	}
+
+	if len(parts) == 0 {
+		return nil, ErrNoPartitions
+	}
+
+	return
+}
+
+// guidString formats a GUID, the first three groups are little endian
+func guidString(g [16]uint8) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10],
+		g[10:16],
+	)
+}
+
+// diskSize returns the size of r if it can be known, 0 otherwise
+func diskSize(r io.ReaderAt) int64 {
+	switch s := r.(type) {
+	case interface{ Size() int64 }:
+		return s.Size()
+	case interface {
+		Seek(offset int64, whence int) (int64, error)
+	}:
+		// the seek offset isn't used by ReadAt so it can be moved freely
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0
+		}
+		return size
+	}
+	return 0
+}
+
+// FindPartition returns the partition selected by sel, which is either its
+// index, its GPT name or its GPT unique GUID
+func FindPartition(parts []Partition, sel string) (Partition, error) {
+	if n, err := strconv.Atoi(sel); err == nil {
+		for _, p := range parts {
+			if p.Index == n {
+				return p, nil
+			}
+		}
+		return Partition{}, ErrNoPartition
+	}
+
+	for _, p := range parts {
+		if p.Name == sel || strings.EqualFold(p.GUID, sel) {
+			return p, nil
+		}
+	}
+
+	return Partition{}, ErrNoPartition
+}
+
+// NewPartition returns the volume stored inside partition p of the disk r
+func NewPartition(r io.ReaderAt, p Partition) (*Volume, error) {
+	section := io.NewSectionReader(r, p.Offset, p.Size)
+
+	if w, ok := r.(io.WriterAt); ok {
+		return newVolume(section, &sectionWriter{w, p.Offset, p.Size})
+	}
+
+	return newVolume(section, nil)
+}
+
+// sectionWriter writes to the size bytes of w starting at off, the writer
+// side of io.SectionReader
+type sectionWriter struct {
+	w    io.WriterAt
+	off  int64
+	size int64
+}
+
+func (s *sectionWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off > s.size {
+		return 0, ErrPartitionEnd
+	}
+
+	if left := s.size - off; int64(len(p)) > left {
+		if n, err = s.w.WriteAt(p[:left], s.off+off); err == nil {
+			err = ErrPartitionEnd
+		}
+		return
+	}
+
+	return s.w.WriteAt(p, s.off+off)
+}
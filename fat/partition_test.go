@@ -0,0 +1,110 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// gptImage returns a disk with a GPT header on its second sector whose
+// entries are entrySize bytes long and start with parts. The CRCs are always
+// right
+func gptImage(entrySize uint32, parts ...gptEntry) memImage {
+	const ss = 512
+
+	img := make(memImage, 64*ss)
+	header := gptHeader{
+		HeaderSize:     gptHeaderSize,
+		MyLBA:          1,
+		AlternateLBA:   63,
+		FirstUsableLBA: 34,
+		LastUsableLBA:  62,
+		EntryLBA:       2,
+		EntryCount:     4,
+		EntrySize:      entrySize,
+	}
+	copy(header.Signature[:], gptSignature)
+
+	for i, e := range parts {
+		if _, err := binary.Encode(img[2*ss+i*int(entrySize):], binary.LittleEndian, e); err != nil {
+			panic(err)
+		}
+	}
+
+	entries := img[2*ss : 2*ss+min(int(4*entrySize), 32*ss)]
+	header.EntryCRC32 = crc32.ChecksumIEEE(entries)
+
+	raw, _ := binary.Append(nil, binary.LittleEndian, header)
+	binary.LittleEndian.PutUint32(raw[16:], crc32.ChecksumIEEE(raw))
+	copy(img[ss:], raw)
+
+	return img
+}
+
+func TestGPTEntrySize(t *testing.T) {
+	for _, size := range []uint32{128, 256, 512} {
+		if _, err := readGPTHeader(gptImage(size), 512, 1); err != nil {
+			t.Errorf("entry size %d: %v", size, err)
+		}
+	}
+
+	for _, size := range []uint32{0, 64, 129, 200, 1024, 0xffffff80, 0xffffffff} {
+		if _, err := readGPTHeader(gptImage(size), 512, 1); !errors.Is(err, ErrBadGPT) {
+			t.Errorf("entry size %#x: got %v, want %v", size, err, ErrBadGPT)
+		}
+	}
+}
+
+func TestGPTEntryBounds(t *testing.T) {
+	entry := gptEntry{TypeGUID: [16]uint8{1}, FirstLBA: 40, LastLBA: 40}
+
+	img := gptImage(gptEntrySize, entry)
+	header, err := readGPTHeader(img, 512, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := readGPTEntries(img, 512, header)
+	if err != nil || len(parts) != 1 || parts[0].Offset != 40*512 || parts[0].Size != 512 {
+		t.Fatalf("got %+v, %v", parts, err)
+	}
+
+	entry.LastLBA = 39
+	img = gptImage(gptEntrySize, entry)
+	if header, err = readGPTHeader(img, 512, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readGPTEntries(img, 512, header); !errors.Is(err, ErrBadGPT) {
+		t.Fatalf("got %v, want %v", err, ErrBadGPT)
+	}
+}
+
+func TestPartitionWriteBounds(t *testing.T) {
+	const offset, size = 1 << 20, 1440 << 10
+
+	disk := make(memImage, offset+size+512)
+	if err := Format(io.NewOffsetWriter(disk, offset), size, FormatOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewPartition(disk, Partition{Offset: offset, Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.writeAt(size-2, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.writeAt(size-1, []byte{3, 4}); err != ErrPartitionEnd {
+		t.Fatalf("got %v, want %v", err, ErrPartitionEnd)
+	}
+	if err = v.writeAt(size+1, []byte{5}); err != ErrPartitionEnd {
+		t.Fatalf("got %v, want %v", err, ErrPartitionEnd)
+	}
+
+	if got := disk[offset+size-2 : offset+size+2]; !bytes.Equal(got, []byte{1, 3, 0, 0}) {
+		t.Fatalf("got % x around the end of the partition", got)
+	}
+}
@@ -0,0 +1,85 @@
+package fat
+
+// RemoveOptions configures Remove
+type RemoveOptions struct {
+	Recursive bool // remove directories together with everything inside them
+	Secure    bool // zero the freed clusters
+}
+
+// Remove deletes the file or directory at path and frees its clusters.
+// Directories must be empty unless opts.Recursive is set
+func (v *Volume) Remove(path string, opts RemoveOptions) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	entry, err := v.lookup(splitPath(path))
+	if err != nil {
+		return
+	}
+
+	return v.removeEntry(entry, opts)
+}
+
+func (v *Volume) removeEntry(entry EntryInfo, opts RemoveOptions) (err error) {
+	if entry.Attr&AttrDir != 0 {
+		children, err := v.readDir(entry.Location)
+		if err != nil {
+			return err
+		}
+
+		if len(children) != 0 && !opts.Recursive {
+			return ErrNotEmpty
+		}
+
+		for _, child := range children {
+			if err = v.removeEntry(child, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the slots go first so a failure leaves lost clusters behind instead
+	// of an entry pointing to free ones
+	if err = v.deleteSlots(entry.dir, entry.slot-entry.nlong, entry.nlong+1); err != nil {
+		return
+	}
+
+	if entry.Location == 0 {
+		return
+	}
+
+	if opts.Secure {
+		clusters, err := v.chain(entry.Location)
+		for _, c := range clusters {
+			if e := v.zeroCluster(c); e != nil {
+				return e
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return v.freeChain(entry.Location)
+}
+
+// Rmdir deletes the directory at path, it must be empty
+func (v *Volume) Rmdir(path string) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	entry, err := v.lookup(splitPath(path))
+	if err != nil {
+		return
+	}
+
+	if entry.Attr&AttrDir == 0 {
+		return ErrNotDir
+	}
+
+	// removeEntry refuses to delete it if there's anything besides the
+	// dot entries
+	return v.removeEntry(entry, RemoveOptions{})
+}
@@ -0,0 +1,181 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testFormats are small volumes of every FAT type
+var testFormats = []struct {
+	size int64
+	opts FormatOptions
+}{
+	{1440 << 10, FormatOptions{FATBits: 12}},
+	{16 << 20, FormatOptions{FATBits: 16}},
+	{40 << 20, FormatOptions{FATBits: 32, SectorsPerCluster: 1}},
+}
+
+// checkFree compares the free clusters with want and, if there's an FSInfo
+// sector, with its free count
+func checkFree(t *testing.T, v *Volume, want uint32) {
+	t.Helper()
+
+	free, err := v.FreeClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != want {
+		t.Errorf("%d free clusters, want %d", free, want)
+	}
+	if v.FSInfo.Valid() && v.FSInfo.FreeCount != free {
+		t.Errorf("FSInfo free count is %d, want %d", v.FSInfo.FreeCount, free)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	const name = "a long file name.txt"
+
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, img := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content := bytes.Repeat([]byte{0xaa}, 3*int(v.Info.ClusterSize))
+			if err = v.WriteFile(name, bytes.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+			checkFree(t, v, free-3)
+
+			e, err := v.Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.nlong == 0 {
+				t.Fatal("no long name slots")
+			}
+			clusters, err := v.chain(e.Location)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = v.Remove(name, RemoveOptions{Secure: true}); err != nil {
+				t.Fatal(err)
+			}
+			checkFree(t, v, free)
+
+			if _, err = v.Lookup(name); err != ErrNotFound {
+				t.Fatalf("got %v, want %v", err, ErrNotFound)
+			}
+
+			// the long slots are deleted together with the short one
+			d, err := v.openDir(e.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := e.slot - e.nlong; i <= e.slot; i++ {
+				if b := img[d.slotOffset(i)]; b != NameDeleted {
+					t.Errorf("slot %d starts with %#x", i, b)
+				}
+			}
+			slots, err := v.ListSlots("", SlotLong)
+			if err != nil || len(slots) != 0 {
+				t.Fatalf("%d long slots left, %v", len(slots), err)
+			}
+
+			// Secure zeroed the clusters
+			for _, c := range clusters {
+				offset := int64(getFileOffset(c, v.BPB, v.Info))
+				if !bytes.Equal(img[offset:offset+int64(v.Info.ClusterSize)], make([]byte, v.Info.ClusterSize)) {
+					t.Errorf("cluster %d not zeroed", c)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveRecursive(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = v.Mkdir("dir/sub", true); err != nil {
+				t.Fatal(err)
+			}
+			for i := range 40 {
+				if err = v.WriteFile(fmt.Sprintf("dir/sub/file number %d", i), bytes.NewReader(make([]byte, i*100))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = v.Remove("dir", RemoveOptions{}); err != ErrNotEmpty {
+				t.Fatalf("got %v, want %v", err, ErrNotEmpty)
+			}
+			if err = v.Remove("dir", RemoveOptions{Recursive: true}); err != nil {
+				t.Fatal(err)
+			}
+			checkFree(t, v, free)
+
+			report, err := v.Check(CheckOptions{})
+			if err != nil || report.Status() != CheckClean {
+				t.Fatalf("%v: %v", report.Problems, err)
+			}
+		})
+	}
+}
+
+func TestRmdir(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = v.Mkdir("dir/sub", true); err != nil {
+				t.Fatal(err)
+			}
+			if err = v.WriteFile("dir/file", bytes.NewReader([]byte("file"))); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, c := range []struct {
+				path string
+				want error
+			}{
+				{"dir", ErrNotEmpty},
+				{"dir/file", ErrNotDir},
+				{"dir/missing", ErrNotFound},
+				{"dir/sub", nil},
+				{"dir", ErrNotEmpty},
+			} {
+				if err = v.Rmdir(c.path); err != c.want {
+					t.Fatalf("%s: got %v, want %v", c.path, err, c.want)
+				}
+			}
+
+			if err = v.Remove("dir/file", RemoveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err = v.Rmdir("dir"); err != nil {
+				t.Fatal(err)
+			}
+			checkFree(t, v, free)
+
+			if entries, err := v.List(""); err != nil || len(entries) != 0 {
+				t.Fatalf("%d entries left in the root, %v", len(entries), err)
+			}
+		})
+	}
+}
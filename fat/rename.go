@@ -0,0 +1,132 @@
+package fat
+
+// Rename moves the entry at oldpath to newpath. If newpath is an existing
+// directory the entry is moved inside it keeping its name. An existing
+// target is only replaced if overwrite is set. Only the directory slots are
+// moved, the data stays where it is
+func (v *Volume) Rename(oldpath, newpath string, overwrite bool) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	src, err := v.lookup(splitPath(oldpath))
+	if err != nil {
+		return
+	}
+
+	dst := splitPath(newpath)
+	if len(dst) == 0 {
+		dst = []string{src.Name()}
+	} else if into, e := v.lookup(dst); e == nil && into.Attr&AttrDir != 0 && !sameEntry(into, src) {
+		dst = append(dst, src.Name())
+	}
+	name := dst[len(dst)-1]
+
+	if src.Attr&AttrDir != 0 {
+		// a directory can't end up inside itself
+		for i := range dst[:len(dst)-1] {
+			if e, err := v.lookup(dst[:i+1]); err == nil && e.Location == src.Location {
+				return ErrMoveLoop
+			}
+		}
+	}
+
+	parent, err := v.lookupDir(dst[:len(dst)-1])
+	if err != nil {
+		return
+	}
+
+	target, err := v.lookup(dst)
+	switch {
+	case err == ErrNotFound:
+		err = nil
+	case err != nil:
+		return
+	case sameEntry(target, src):
+		// only the case of the name changes
+	case !overwrite:
+		return ErrExists
+	case target.Attr&AttrDir != 0 && src.Attr&AttrDir == 0:
+		return ErrExists
+	case target.Attr&AttrDir == 0 && src.Attr&AttrDir != 0:
+		return ErrNotDir
+	default:
+		// directories are only replaced if they are empty
+		if err = v.removeEntry(target, RemoveOptions{}); err != nil {
+			return
+		}
+	}
+
+	shortName, err := v.uniqueShortName(parent, name, &src)
+	if err != nil {
+		return
+	}
+
+	longEntries, ntres, err := v.nameSlots(name, shortName)
+	if err != nil {
+		return
+	}
+
+	d, err := v.openDir(parent)
+	if err != nil {
+		return
+	}
+
+	// the new slots are written before the old ones are deleted. A full
+	// root directory can still take the new name over the old slots if
+	// it fits in them
+	first, count := src.slot-src.nlong, src.nlong+1
+	index, err := v.findFreeSlots(&d, len(longEntries)+1)
+	reuse := false
+	if err == ErrDirFull && d.cluster == src.dir && len(longEntries)+1 <= count {
+		index, reuse, err = first, true, nil
+	}
+	if err != nil {
+		return
+	}
+
+	entry := src.Entry
+	entry.Name = shortName
+	entry.NTRes = entry.NTRes&^(NTResLowerBase|NTResLowerExt) | ntres
+
+	if err = v.putSlots(d, index, longEntries, entry); err != nil {
+		return
+	}
+
+	if reuse {
+		first += len(longEntries) + 1
+		count -= len(longEntries) + 1
+	}
+	if err = v.deleteSlots(src.dir, first, count); err != nil {
+		return
+	}
+
+	if src.Attr&AttrDir == 0 || d.cluster == src.dir {
+		return
+	}
+
+	return v.fixDotDot(src.Location, parent)
+}
+
+// fixDotDot points the ".." entry of the directory starting at cluster to
+// parent
+func (v *Volume) fixDotDot(cluster, parent uint32) error {
+	dots, err := v.readDirSlots(cluster, SlotDot)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range dots {
+		if e.Entry.Name == dotDotName {
+			e.Location = v.dotDotCluster(parent)
+			return v.writeEntry(e)
+		}
+	}
+
+	return nil
+}
+
+// sameEntry reports whether a and b are stored in the same slot
+func sameEntry(a, b EntryInfo) bool {
+	return a.dir == b.dir && a.slot == b.slot
+}
@@ -0,0 +1,156 @@
+package fat
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// dotDotOf returns the cluster the ".." entry of the directory at path
+// points to
+func dotDotOf(t *testing.T, v *Volume, path string) uint32 {
+	t.Helper()
+
+	e, err := v.Lookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dots, err := v.readDirSlots(e.Location, SlotDot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dot := range dots {
+		if dot.Entry.Name == dotDotName {
+			return dot.Location
+		}
+	}
+
+	t.Fatalf("%s: no \"..\" entry", path)
+	return 0
+}
+
+// checkClean fails unless Check finds nothing wrong
+func checkClean(t *testing.T, v *Volume, what string) {
+	t.Helper()
+
+	report, err := v.Check(CheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status() != CheckClean {
+		t.Fatalf("%s: %v", what, report.Problems)
+	}
+}
+
+func TestRenameDir(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			for _, dir := range []string{"x/moved/inner", "y"} {
+				if err := v.Mkdir(dir, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := v.WriteFile("x/moved/file", bytes.NewReader([]byte("file"))); err != nil {
+				t.Fatal(err)
+			}
+
+			y, err := v.Lookup("y")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// into another directory, then back to the root where ".."
+			// points to 0 even on FAT32
+			for _, c := range []struct {
+				from, to, path string
+				parent         uint32
+			}{
+				{"x/moved", "y", "y/moved", y.Location},
+				{"y/moved", "/", "moved", 0},
+				{"moved", "y/renamed", "y/renamed", y.Location},
+			} {
+				if err = v.Rename(c.from, c.to, false); err != nil {
+					t.Fatalf("%s to %s: %v", c.from, c.to, err)
+				}
+				if got := dotDotOf(t, v, c.path); got != c.parent {
+					t.Errorf("%s: \"..\" points to %d, want %d", c.path, got, c.parent)
+				}
+
+				// the directories inside keep pointing to it
+				moved, _ := v.Lookup(c.path)
+				if got := dotDotOf(t, v, c.path+"/inner"); got != moved.Location {
+					t.Errorf("%s/inner: \"..\" points to %d, want %d", c.path, got, moved.Location)
+				}
+				if b, err := v.ReadFile(c.path + "/file"); err != nil || string(b) != "file" {
+					t.Errorf("%s/file: %q %v", c.path, b, err)
+				}
+
+				checkClean(t, v, c.path)
+			}
+		})
+	}
+}
+
+func TestRenameOverwrite(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(fmt.Sprintf("FAT%d", format.opts.FATBits), func(t *testing.T) {
+			v, _ := newImage(t, format.size, format.opts)
+
+			free, err := v.FreeClusters()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := int(v.Info.ClusterSize)
+			src := bytes.Repeat([]byte{1}, 3*cs)
+			if err = v.WriteFile("source file", bytes.NewReader(src)); err != nil {
+				t.Fatal(err)
+			}
+			if err = v.WriteFile("target file", bytes.NewReader(bytes.Repeat([]byte{2}, 2*cs))); err != nil {
+				t.Fatal(err)
+			}
+			checkFree(t, v, free-5)
+
+			if err = v.Rename("source file", "target file", false); err != ErrExists {
+				t.Fatalf("got %v, want %v", err, ErrExists)
+			}
+			if err = v.Rename("source file", "target file", true); err != nil {
+				t.Fatal(err)
+			}
+
+			// the chain of the old target is freed
+			checkFree(t, v, free-3)
+
+			if _, err = v.Lookup("source file"); err != ErrNotFound {
+				t.Fatalf("got %v, want %v", err, ErrNotFound)
+			}
+			if b, err := v.ReadFile("target file"); err != nil || !bytes.Equal(b, src) {
+				t.Fatalf("read %d bytes, %v", len(b), err)
+			}
+
+			checkClean(t, v, "overwritten")
+		})
+	}
+}
+
+func TestRenameIntoItself(t *testing.T) {
+	v, img := newImage(t, 16<<20, FormatOptions{})
+
+	if err := v.Mkdir("d/e", true); err != nil {
+		t.Fatal(err)
+	}
+
+	before := bytes.Clone(img)
+	for _, to := range []string{"d/e", "d/e/f", "d/new"} {
+		if err := v.Rename("d", to, true); err != ErrMoveLoop {
+			t.Errorf("d to %s: got %v, want %v", to, err, ErrMoveLoop)
+		}
+	}
+	if !bytes.Equal(before, img) {
+		t.Fatal("failed renames changed the image")
+	}
+
+	checkClean(t, v, "after failed renames")
+}
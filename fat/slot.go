@@ -0,0 +1,151 @@
+package fat
+
+import "strings"
+
+// SlotKind classifies a 32 byte directory slot. Kinds are bit flags so
+// they can be combined to ask for several of them when listing
+type SlotKind uint8
+
+const (
+	SlotShort   SlotKind = 1 << iota // regular file or directory
+	SlotDot                          // "." or ".." entry of a subdirectory
+	SlotVolume                       // volume label
+	SlotDeleted                      // entry deleted by setting its first byte to 0xe5
+	SlotLong                         // long filename part
+	SlotEnd                          // first byte 0x00, no more entries after this one
+	SlotFree                         // unused slot after the end marker
+
+	SlotLive = SlotShort
+	SlotAll  = SlotShort | SlotDot | SlotVolume | SlotDeleted
+)
+
+// first byte of the name field
+const (
+	NameEnd     = 0x00 // this and every following slot is free
+	NameDeleted = 0xe5 // the slot is free
+	NameKanji   = 0x05 // the real first byte is 0xe5
+)
+
+var (
+	dotName    = [11]byte{'.', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' '}
+	dotDotName = [11]byte{'.', '.', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' '}
+)
+
+func (k SlotKind) String() string {
+	switch k {
+	case SlotShort:
+		return "short"
+	case SlotDot:
+		return "dot"
+	case SlotVolume:
+		return "volume"
+	case SlotDeleted:
+		return "deleted"
+	case SlotLong:
+		return "long"
+	case SlotEnd:
+		return "end"
+	case SlotFree:
+		return "free"
+	}
+	return "unknown"
+}
+
+// classifySlot decodes what's stored inside a raw directory slot. The first
+// byte of the name is checked before the attribute because deleted and free
+// slots keep whatever attribute they had
+func classifySlot(slot []byte) SlotKind {
+	switch slot[0] {
+	case NameEnd:
+		return SlotEnd
+	case NameDeleted:
+		return SlotDeleted
+	}
+
+	attr := slot[11]
+
+	switch {
+	case attr&0x3f == AttrLongName:
+		return SlotLong
+	case attr&AttrVolID != 0:
+		return SlotVolume
+	case [11]byte(slot[:11]) == dotName, [11]byte(slot[:11]) == dotDotName:
+		return SlotDot
+	}
+
+	return SlotShort
+}
+
+// shortName decodes the short name stored in a slot as NAME.EXT undoing the
+// 0x05 escape of names that really start with 0xe5 and applying the NTRes
+// case flags. Volume labels are decoded whole
+func (v *Volume) shortName(name Str11Byte, ntres uint8, kind SlotKind) string {
+	if name[0] == NameKanji {
+		name[0] = NameDeleted
+	}
+
+	if kind == SlotVolume {
+		return strings.TrimRight(v.CodePage.decode(name[:]), " ")
+	}
+
+	base := strings.TrimRight(v.CodePage.decode(name[:8]), " ")
+	ext := strings.TrimRight(v.CodePage.decode(name[8:]), " ")
+
+	if ntres&NTResLowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if ntres&NTResLowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+
+	return joinShort(base, ext)
+}
+
+// longest valid sequence of long entries (255 characters / 13 per entry)
+const maxLongEntries = 20
+
+// longNameSeq collects the long entries that precede a short entry. Ordinals
+// must go from N|0x40 down to 1 and all the parts must share the checksum
+type longNameSeq struct {
+	parts    [][]uint16
+	checksum uint8
+	next     uint8 // ordinal expected for the next part, 0 once complete
+	first    int   // index of the slot holding the first part
+}
+
+// add appends the long entry stored at slot index. It returns false if the
+// entry doesn't continue the sequence
+func (s *longNameSeq) add(entry DirEntryLong, index int) bool {
+	ord := uint8(entry.Ordinal)
+	n := ord &^ LastEntryLong
+
+	if ord&LastEntryLong != 0 {
+		if len(s.parts) != 0 || n == 0 || n > maxLongEntries {
+			return false
+		}
+
+		*s = longNameSeq{
+			parts:    [][]uint16{longNameParts(entry)},
+			checksum: entry.Checksum,
+			next:     n - 1,
+			first:    index,
+		}
+		return true
+	}
+
+	if s.next == 0 || n != s.next || entry.Checksum != s.checksum {
+		return false
+	}
+
+	s.parts = append(s.parts, longNameParts(entry))
+	s.next--
+
+	return true
+}
+
+// belongsTo reports whether the sequence is complete and its checksum
+// matches the short name
+func (s *longNameSeq) belongsTo(name Str11Byte) bool {
+	sum, _ := checksum(name[:])
+	return len(s.parts) != 0 && s.next == 0 && sum == s.checksum
+}
@@ -0,0 +1,360 @@
+package fat
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+func mkentry(t uint8) (eof uint32, fatEntry []byte) {
+	switch t {
+	case FAT12:
+		fatEntry = make([]byte, 2)
+		eof = 0xfff
+	case FAT16:
+		fatEntry = make([]byte, 2)
+		eof = 0xffff
+	case FAT32:
+		fatEntry = make([]byte, 4)
+		eof = 0xfffffff
+	}
+	return
+}
+
+// locFromEntry extracts the value stored for cluster location from fatEntry.
+// FAT12 entries are 12 bits wide so two consecutive entries share three bytes,
+// odd clusters live in the high 12 bits of the word and even ones in the low 12
+func locFromEntry(t uint8, location uint32, fatEntry []byte) uint32 {
+	switch t {
+	case FAT12:
+		packed := binary.LittleEndian.Uint16(fatEntry)
+		if location&1 == 1 {
+			return uint32(packed >> 4)
+		}
+		return uint32(packed & 0xfff)
+	case FAT16:
+		return uint32(binary.LittleEndian.Uint16(fatEntry))
+	case FAT32:
+		// the high 4 bits of a FAT32 entry are reserved
+		return binary.LittleEndian.Uint32(fatEntry) & 0xfffffff
+	}
+	panic("not a correct fat type")
+}
+
+// putLocToEntry stores value for cluster location into fatEntry. fatEntry has
+// to hold the current content of the FAT because FAT12 shares a nibble with
+// the neighbour entry and FAT32 keeps its reserved high bits
+func putLocToEntry(t uint8, entry []byte, location, value uint32) {
+	switch t {
+	case FAT12:
+		packed := binary.LittleEndian.Uint16(entry)
+		if location&1 == 1 {
+			packed = packed&0x000f | uint16(value&0xfff)<<4
+		} else {
+			packed = packed&0xf000 | uint16(value&0xfff)
+		}
+		binary.LittleEndian.PutUint16(entry, packed)
+	case FAT16:
+		binary.LittleEndian.PutUint16(entry, uint16(value))
+	case FAT32:
+		reserved := binary.LittleEndian.Uint32(entry) & 0xf0000000
+		binary.LittleEndian.PutUint32(entry, reserved|value&0xfffffff)
+	}
+}
+
+// isEOF reports whether location marks the end of a cluster chain
+func isEOF(t uint8, location uint32) bool {
+	switch t {
+	case FAT12:
+		return location >= 0xff8
+	case FAT16:
+		return location >= 0xfff8
+	case FAT32:
+		return location&0xfffffff >= 0xffffff8
+	}
+	return false
+}
+
+// isBad reports whether location is the marker of a bad cluster
+func isBad(t uint8, location uint32) bool {
+	switch t {
+	case FAT12:
+		return location == 0xff7
+	case FAT16:
+		return location == 0xfff7
+	case FAT32:
+		return location&0xfffffff == 0xffffff7
+	}
+	return false
+}
+
+// validCluster reports whether n is a cluster inside the data region
+func (v *Volume) validCluster(n uint32) bool {
+	return n >= 2 && n < v.Info.ClusterCount+2
+}
+
+// chain returns every cluster of the chain that starts at first
+func (v *Volume) chain(first uint32) (clusters []uint32, err error) {
+	for location := first; !isEOF(v.Info.Type, location); {
+		if !v.validCluster(location) || uint32(len(clusters)) > v.Info.ClusterCount {
+			// either the chain points outside the data region or it loops
+			return clusters, ErrBadChain
+		}
+
+		clusters = append(clusters, location)
+
+		if location, err = v.FATEntry(location); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func getFileOffset(location uint32, bpb BPB, info FATInfo) uint32 {
+	// here we calculate the file offset inside the file region
+	// the first two clusters numbers are reserved
+	// so we substract them from the `Location` number
+	return info.DataOffset + (location-2)*uint32(bpb.SectorPerCluster)*uint32(bpb.BytesPerSector)
+}
+
+func getFATEntryOffset(location uint32, entryLen int, info FATInfo) int64 {
+	if info.Type == FAT12 {
+		// one and a half bytes per entry
+		return int64(info.FATOffset) + int64(location) + int64(location/2)
+	}
+	return int64(info.FATOffset) + int64(entryLen)*int64(location)
+}
+
+// activeFAT returns the FAT copy used for reading. FAT32 can disable
+// mirroring through ExtFlags, in that case only the copy set in its low 4
+// bits is active
+func (v *Volume) activeFAT() uint32 {
+	if !v.mirrored() {
+		return uint32(v.Ext32.ExtFlags[0] & 0x0f)
+	}
+	return 0
+}
+
+// mirrored reports whether FAT updates have to be written to every copy
+func (v *Volume) mirrored() bool {
+	return v.Info.Type != FAT32 || v.Ext32.ExtFlags[0]&0x80 == 0
+}
+
+// fatCopyOffset returns how far FAT copy number n is from the first one
+func (v *Volume) fatCopyOffset(n uint32) int64 {
+	return int64(n) * int64(v.Info.FATSectors) * int64(v.Info.SectorSize)
+}
+
+// FATEntry returns the value stored in the FAT for cluster n
+func (v *Volume) FATEntry(n uint32) (uint32, error) {
+	_, fatEntry := mkentry(v.Info.Type)
+	offset := getFATEntryOffset(n, len(fatEntry), v.Info) + v.fatCopyOffset(v.activeFAT())
+
+	if err := v.readAt(offset, fatEntry); err != nil {
+		return 0, err
+	}
+
+	return locFromEntry(v.Info.Type, n, fatEntry), nil
+}
+
+// bytes of the FAT loaded at once by fatReader
+const fatWindowSize = 64 * 1024
+
+// fatReader reads entries of the active FAT through a window of it, so
+// following a long chain doesn't take a read for every cluster. It must not
+// be used while the FAT changes
+type fatReader struct {
+	v    *Volume
+	base int64 // volume offset of the window
+	buf  []byte
+}
+
+func (v *Volume) newFATReader() *fatReader {
+	return &fatReader{v: v}
+}
+
+// entry returns the value stored in the FAT for cluster n
+func (r *fatReader) entry(n uint32) (uint32, error) {
+	v := r.v
+	_, fatEntry := mkentry(v.Info.Type)
+
+	copyOffset := v.fatCopyOffset(v.activeFAT())
+	offset := getFATEntryOffset(n, len(fatEntry), v.Info) + copyOffset
+	end := offset + int64(len(fatEntry))
+
+	if r.buf == nil || offset < r.base || end > r.base+int64(len(r.buf)) {
+		// the window never goes past the end of the FAT copy
+		fatEnd := int64(v.Info.FATOffset) + copyOffset + v.fatCopyOffset(1)
+		if end > fatEnd {
+			return v.FATEntry(n)
+		}
+
+		size := min(fatWindowSize, fatEnd-offset)
+		if int64(cap(r.buf)) < size {
+			r.buf = make([]byte, size)
+		}
+		r.buf = r.buf[:size]
+
+		if err := v.readBytes(offset, r.buf); err != nil {
+			r.buf = nil
+			return 0, err
+		}
+		r.base = offset
+	}
+
+	return locFromEntry(v.Info.Type, n, r.buf[offset-r.base:]), nil
+}
+
+// readFAT returns every entry of FAT copy n
+func (v *Volume) readFAT(n uint32) (entries []uint32, raw []byte, err error) {
+	raw = make([]byte, v.Info.FATSectors*v.Info.SectorSize)
+
+	if err = v.readAt(int64(v.Info.FATOffset)+v.fatCopyOffset(n), raw); err != nil {
+		return
+	}
+
+	_, fatEntry := mkentry(v.Info.Type)
+	entries = make([]uint32, v.FATEntries())
+
+	for i := range entries {
+		offset := getFATEntryOffset(uint32(i), len(fatEntry), v.Info) - int64(v.Info.FATOffset)
+		copy(fatEntry, raw[offset:])
+		entries[i] = locFromEntry(v.Info.Type, uint32(i), fatEntry)
+	}
+
+	return
+}
+
+// setFATEntry stores value in the FAT for cluster n. The value is written to
+// every FAT copy unless mirroring is disabled
+func (v *Volume) setFATEntry(n, value uint32) (err error) {
+	copies := []uint32{v.activeFAT()}
+	if v.mirrored() {
+		copies = copies[:0]
+		for i := uint32(0); i < v.Info.FATNumber; i++ {
+			copies = append(copies, i)
+		}
+	}
+
+	_, fatEntry := mkentry(v.Info.Type)
+
+	for _, c := range copies {
+		offset := getFATEntryOffset(n, len(fatEntry), v.Info) + v.fatCopyOffset(c)
+
+		// read the entry first, it might share bits with its neighbour
+		if err = v.readAt(offset, fatEntry); err != nil {
+			return
+		}
+
+		putLocToEntry(v.Info.Type, fatEntry, n, value)
+
+		if err = v.writeAt(offset, fatEntry); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// FATEntries returns the number of entries that fit in a single FAT
+func (v *Volume) FATEntries() uint32 {
+	size := v.Info.FATSectors * v.Info.SectorSize
+
+	if v.Info.Type == FAT12 {
+		return size * 2 / 3
+	}
+
+	_, fatEntry := mkentry(v.Info.Type)
+	return size / uint32(len(fatEntry))
+}
+
+func (v *Volume) findEmptyFAT(startLoc uint32) (emptyLoc uint32, err error) {
+	// this function finds the next empty location inside the FAT region
+	// wrapping around to the first cluster if there's none after startLoc
+
+	// entries past the last cluster of the data region are never used
+	last := min(v.FATEntries(), v.Info.ClusterCount+2)
+	if startLoc < 2 || startLoc >= last {
+		startLoc = 2
+	}
+
+	for n := uint32(0); n < last-2; n++ {
+		i := startLoc + n
+		if i >= last {
+			i -= last - 2
+		}
+
+		loc, err := v.FATEntry(i)
+		if err != nil {
+			return 0, err
+		}
+
+		if loc == 0 {
+			return i, nil
+		}
+	}
+
+	return 0, ErrNoSpace
+}
+
+// allocCluster takes a free cluster, marks it as the end of a chain and links
+// it after prev. If prev is 0 a new chain is started. The FSInfo changes are
+// only kept in memory so a chain costs a single write, callers store them
+// with writeFSInfo once they are done
+func (v *Volume) allocCluster(prev uint32) (cluster uint32, err error) {
+	eof, _ := mkentry(v.Info.Type)
+
+	start := prev + 1
+	if prev == 0 {
+		start = v.nextFreeHint()
+	}
+
+	if cluster, err = v.findEmptyFAT(start); err != nil {
+		return
+	}
+
+	if err = v.setFATEntry(cluster, eof); err != nil {
+		return
+	}
+
+	if prev != 0 {
+		if err = v.setFATEntry(prev, cluster); err != nil {
+			return
+		}
+	}
+
+	// the search for the next one starts right after it
+	v.countFSInfo(-1, cluster+1)
+
+	return
+}
+
+// freeChain marks every cluster of the chain starting at first as free
+func (v *Volume) freeChain(first uint32) (err error) {
+	clusters, err := v.chain(first)
+
+	for _, c := range clusters {
+		if e := v.setFATEntry(c, 0); e != nil {
+			return e
+		}
+	}
+
+	// the search for free clusters starts again from the lowest one freed
+	next := uint32(0)
+	if len(clusters) != 0 {
+		if lowest := slices.Min(clusters); lowest < v.FSInfo.NextFree {
+			next = lowest
+		}
+	}
+
+	if e := v.updateFSInfo(int64(len(clusters)), next); e != nil {
+		return e
+	}
+
+	return
+}
+
+// zeroCluster fills cluster with zeros
+func (v *Volume) zeroCluster(cluster uint32) error {
+	return v.writeAt(int64(getFileOffset(cluster, v.BPB, v.Info)), make([]byte, v.Info.ClusterSize))
+}
@@ -0,0 +1,155 @@
+package fat
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLocFromEntryFAT12(t *testing.T) {
+	// clusters 2 and 3 holding 0x123 and 0x456 share the bytes 23 61 45
+	packed := []byte{0x23, 0x61, 0x45}
+
+	if got := locFromEntry(FAT12, 2, packed[0:2]); got != 0x123 {
+		t.Errorf("even cluster: got %#x, want 0x123", got)
+	}
+	if got := locFromEntry(FAT12, 3, packed[1:3]); got != 0x456 {
+		t.Errorf("odd cluster: got %#x, want 0x456", got)
+	}
+}
+
+func TestPutLocToEntryFAT12(t *testing.T) {
+	packed := []byte{0xff, 0xff, 0xff}
+
+	// the nibble shared with the neighbour must be kept
+	putLocToEntry(FAT12, packed[0:2], 2, 0x123)
+	if want := []byte{0x23, 0xf1, 0xff}; !bytes.Equal(packed, want) {
+		t.Fatalf("even cluster: got % x, want % x", packed, want)
+	}
+
+	putLocToEntry(FAT12, packed[1:3], 3, 0x456)
+	if want := []byte{0x23, 0x61, 0x45}; !bytes.Equal(packed, want) {
+		t.Fatalf("odd cluster: got % x, want % x", packed, want)
+	}
+
+	putLocToEntry(FAT12, packed[0:2], 2, 0xabc)
+	if want := []byte{0xbc, 0x6a, 0x45}; !bytes.Equal(packed, want) {
+		t.Fatalf("even cluster again: got % x, want % x", packed, want)
+	}
+}
+
+func TestPutLocToEntryFAT32(t *testing.T) {
+	entry := []byte{0, 0, 0, 0xf0}
+
+	putLocToEntry(FAT32, entry, 2, 0xfffffff)
+	if want := []byte{0xff, 0xff, 0xff, 0xff}; !bytes.Equal(entry, want) {
+		t.Fatalf("got % x, want % x", entry, want)
+	}
+	if got := locFromEntry(FAT32, 2, entry); got != 0xfffffff {
+		t.Fatalf("reserved bits not masked: got %#x", got)
+	}
+}
+
+func TestSetFATEntryFAT12(t *testing.T) {
+	v, img := newImage(t, 1440<<10, FormatOptions{FATBits: 12})
+
+	sector := v.Info.SectorSize
+	// cluster 341 starts at the last byte of the first sector of the FAT and
+	// cluster 682 at the last byte of the second one
+	for _, n := range []uint32{341, 682} {
+		if offset := n * 3 / 2; offset%sector != sector-1 {
+			t.Fatalf("cluster %d starts at %d, not at the end of a sector", n, offset)
+		}
+	}
+
+	clusters := []uint32{2, 3, 4, 5, 340, 341, 342, 343, 681, 682, 683}
+	value := func(n uint32) uint32 { return (0xa5c ^ n*7) & 0xfff }
+
+	for _, n := range clusters {
+		if err := v.setFATEntry(n, value(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range clusters {
+		got, err := v.FATEntry(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != value(n) {
+			t.Errorf("cluster %d: got %#x, want %#x", n, got, value(n))
+		}
+	}
+
+	// the entries split between two sectors are laid out in the image the
+	// same way as inside a single sector
+	first := int64(v.Info.FATOffset)
+	for _, n := range []uint32{341, 682} {
+		offset := first + int64(n*3/2)
+		if got := locFromEntry(FAT12, n, img[offset:offset+2]); got != value(n) {
+			t.Errorf("cluster %d in the image: got %#x, want %#x", n, got, value(n))
+		}
+	}
+
+	// every copy of the FAT holds the same bytes
+	size := int64(v.Info.FATSectors) * int64(sector)
+	for c := uint32(1); c < v.Info.FATNumber; c++ {
+		offset := first + v.fatCopyOffset(c)
+		if !bytes.Equal(img[first:first+size], img[offset:offset+size]) {
+			t.Errorf("FAT copy %d differs from the first one", c)
+		}
+	}
+
+	// the media descriptor and end of chain marker in the first two entries
+	// are untouched
+	if e, _ := v.FATEntry(0); e != 0xff0|uint32(v.BPB.Media) {
+		t.Errorf("entry 0: got %#x", e)
+	}
+	if e, _ := v.FATEntry(1); e != 0xfff {
+		t.Errorf("entry 1: got %#x", e)
+	}
+}
+
+func TestSetFATEntryNotMirrored(t *testing.T) {
+	_, img := newImage(t, 40<<20, FormatOptions{FATBits: 32, SectorsPerCluster: 1, NFATs: 3})
+
+	// mirroring disabled with the second copy active, ExtFlags is at 40
+	img[40], img[41] = 0x80|1, 0
+
+	v, err := New(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.mirrored() || v.activeFAT() != 1 {
+		t.Fatalf("mirrored %v, active FAT %d", v.mirrored(), v.activeFAT())
+	}
+
+	first := int64(v.Info.FATOffset)
+	size := int64(v.Info.FATSectors) * int64(v.Info.SectorSize)
+	copies := func() (fats [][]byte) {
+		for c := range v.Info.FATNumber {
+			offset := first + v.fatCopyOffset(c)
+			fats = append(fats, bytes.Clone(img[offset:offset+size]))
+		}
+		return
+	}
+
+	before := copies()
+
+	content := bytes.Repeat([]byte{1}, 3*int(v.Info.ClusterSize))
+	if err = v.WriteFile("file", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	after := copies()
+	for c := range after {
+		if changed := !bytes.Equal(before[c], after[c]); changed != (c == 1) {
+			t.Errorf("FAT copy %d changed: %v", c, changed)
+		}
+	}
+
+	// reads go through the active copy too
+	b, err := v.ReadFile("file")
+	if err != nil || !bytes.Equal(b, content) {
+		t.Fatalf("read back %d bytes, %v", len(b), err)
+	}
+}
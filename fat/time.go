@@ -0,0 +1,74 @@
+package fat
+
+import (
+	"time"
+)
+
+// range of instants a FAT date and time can hold
+var (
+	minFatTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxFatTime = time.Date(2107, time.December, 31, 23, 59, 58, 0, time.UTC)
+)
+
+// location returns the time zone timestamps are stored in
+func (v *Volume) location() *time.Location {
+	if v.Location == nil {
+		return time.Local
+	}
+	return v.Location
+}
+
+// fatTimeToTime decodes a FAT date and time in loc. tenth is the count of
+// 10ms units creation times carry on top of the 2s granularity. A zero or
+// invalid date gives the zero time
+func fatTimeToTime(d, t uint16, tenth uint8, loc *time.Location) time.Time {
+	year, month, day := (d>>0x9)+1980, d>>0x5&0xf, d&0x1f
+	hours, minutes, seconds := t>>0xb, t>>0x5&0x3f, (t&0x1f)*2
+
+	if month < 1 || month > 12 || day < 1 || hours > 23 || minutes > 59 || seconds > 58 || tenth > 199 {
+		return time.Time{}
+	}
+
+	date := time.Date(
+		int(year),
+		time.Month(month),
+		int(day),
+		int(hours),
+		int(minutes),
+		int(seconds),
+		int(tenth)*int(10*time.Millisecond),
+		loc,
+	)
+
+	// days past the end of the month like February 31st
+	if date.Day() != int(day) {
+		return time.Time{}
+	}
+
+	return date
+}
+
+// timeToFatTime encodes t as a FAT date and time in loc. tenth keeps the 10ms
+// units lost to the 2s granularity. Instants out of the range FAT can hold
+// are clamped to it
+func timeToFatTime(t time.Time, loc *time.Location) (date, tm uint16, tenth uint8) {
+	t = t.In(loc)
+
+	// the range is checked on the wall clock of loc
+	year, month, day := t.Date()
+	hours, minutes, seconds := t.Clock()
+	if wall := time.Date(year, month, day, hours, minutes, seconds, t.Nanosecond(), time.UTC); wall.Before(minFatTime) {
+		t = minFatTime
+	} else if wall.After(maxFatTime) {
+		t = maxFatTime
+	}
+
+	year, month, day = t.Date()
+	hours, minutes, seconds = t.Clock()
+
+	date = uint16(year-1980)<<0x9 | uint16(month)<<0x5 | uint16(day)
+	tm = uint16(hours)<<0xb | uint16(minutes)<<0x5 | uint16(seconds/2)
+	tenth = uint8(seconds%2*100 + t.Nanosecond()/int(10*time.Millisecond))
+
+	return date, tm, tenth
+}
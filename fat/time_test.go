@@ -0,0 +1,64 @@
+package fat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFatTimeRoundTrip(t *testing.T) {
+	loc := time.FixedZone("UTC-3", -3*60*60)
+
+	for _, want := range []time.Time{
+		time.Date(1980, time.January, 1, 0, 0, 0, 0, loc),
+		time.Date(2024, time.February, 29, 23, 59, 59, 990*int(time.Millisecond), loc),
+		time.Date(2107, time.December, 31, 23, 59, 58, 0, loc),
+	} {
+		date, tm, tenth := timeToFatTime(want, loc)
+		if got := fatTimeToTime(date, tm, tenth, loc); !got.Equal(want) {
+			t.Errorf("%v came back as %v", want, got)
+		}
+	}
+}
+
+func TestFatTimeInvalid(t *testing.T) {
+	for _, c := range []struct {
+		date, time uint16
+		tenth      uint8
+	}{
+		{0, 0, 0},
+		{2<<5 | 31, 0, 0},       // February 31st
+		{1<<5 | 1, 24 << 11, 0}, // 24:00
+		{1<<5 | 1, 30, 0},       // 60 seconds
+		{1<<5 | 1, 0, 200},      // 2 seconds of tenths
+		{13<<5 | 1, 0, 0},       // month 13
+	} {
+		if got := fatTimeToTime(c.date, c.time, c.tenth, time.UTC); !got.IsZero() {
+			t.Errorf("%#x %#x %d decoded as %v", c.date, c.time, c.tenth, got)
+		}
+	}
+}
+
+func TestWriteFileTime(t *testing.T) {
+	v, _ := newImage(t, 1440<<10, FormatOptions{})
+	v.Location = time.UTC
+
+	mod := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	if err := v.WriteFileTime("file", strings.NewReader("content"), mod); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := v.Lookup("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Mod.Equal(mod) {
+		t.Errorf("modified at %v, want %v", e.Mod, mod)
+	}
+	if e.Acc.IsZero() {
+		t.Error("no access date")
+	}
+	if time.Since(e.Crt) > time.Minute {
+		t.Errorf("created at %v", e.Crt)
+	}
+}
@@ -0,0 +1,527 @@
+package fat
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// ExtractOptions configures ExtractTree
+type ExtractOptions struct {
+	Recursive bool // extract directories together with everything inside them
+	Hidden    bool // include hidden entries
+	System    bool // include system entries
+	Parallel  int  // files extracted at the same time, 1 if 0
+}
+
+// ExtractTree copies the file or directory at path into dest on the host.
+// If dest is an existing directory the entry is copied inside it. Read only
+// files lose their write permissions and modification times are restored.
+// Directories need opts.Recursive
+func (v *Volume) ExtractTree(path, dest string, opts ExtractOptions) (err error) {
+	var entry EntryInfo
+
+	// the root directory has no entry, its content goes right into dest
+	if len(splitPath(path)) == 0 {
+		entry.Attr = AttrDir
+	} else {
+		if entry, err = v.lookup(splitPath(path)); err != nil {
+			return
+		}
+
+		if stat, e := os.Stat(dest); e == nil && stat.IsDir() {
+			if dest, err = hostPath(dest, entry.Name()); err != nil {
+				return
+			}
+		}
+	}
+
+	if entry.Attr&AttrDir != 0 && !opts.Recursive {
+		return ErrIsDir
+	}
+
+	t := &treeExtractor{v: v, opts: opts, jobs: make(chan extractJob)}
+
+	for range max(opts.Parallel, 1) {
+		t.workers.Add(1)
+		go t.work()
+	}
+
+	if entry.Attr&AttrDir == 0 {
+		t.jobs <- extractJob{entry, dest}
+	} else {
+		t.walk(entry, dest)
+	}
+
+	close(t.jobs)
+	t.workers.Wait()
+
+	// writing the content of a directory changes its time so they are set
+	// once everything is done, the deepest ones first
+	for i := len(t.dirs) - 1; i >= 0 && t.err == nil; i-- {
+		t.fail(os.Chtimes(t.dirs[i].dest, t.dirs[i].entry.Acc, t.dirs[i].entry.Mod))
+	}
+
+	return t.err
+}
+
+type extractJob struct {
+	entry EntryInfo
+	dest  string
+}
+
+// treeExtractor walks the directories while its workers extract the files
+type treeExtractor struct {
+	v    *Volume
+	opts ExtractOptions
+
+	jobs    chan extractJob
+	workers sync.WaitGroup
+	dirs    []extractJob // directories created, parents come first
+
+	mu  sync.Mutex
+	err error // first error found, it stops everything
+}
+
+func (t *treeExtractor) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *treeExtractor) failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err != nil
+}
+
+// walk creates the directory dest and queues everything inside dir
+func (t *treeExtractor) walk(dir EntryInfo, dest string) {
+	if err := os.Mkdir(dest, 0777); err != nil && !os.IsExist(err) {
+		t.fail(err)
+		return
+	}
+	t.dirs = append(t.dirs, extractJob{dir, dest})
+
+	entries, err := t.v.readDir(dir.Location)
+	if err != nil {
+		t.fail(fmt.Errorf("%s: %w", dest, err))
+		return
+	}
+
+	for _, e := range entries {
+		if t.failed() {
+			return
+		}
+
+		if e.Attr&AttrHidden != 0 && !t.opts.Hidden || e.Attr&AttrSystem != 0 && !t.opts.System {
+			continue
+		}
+
+		path, err := hostPath(dest, e.Name())
+		if err != nil {
+			t.fail(err)
+			return
+		}
+
+		if e.Attr&AttrDir != 0 {
+			t.walk(e, path)
+		} else {
+			t.jobs <- extractJob{e, path}
+		}
+	}
+}
+
+func (t *treeExtractor) work() {
+	defer t.workers.Done()
+
+	for job := range t.jobs {
+		// the queue is drained without doing anything once something failed
+		if !t.failed() {
+			t.fail(t.extractFile(job.entry, job.dest))
+		}
+	}
+}
+
+func (t *treeExtractor) extractFile(e EntryInfo, dest string) (err error) {
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+
+	if err = t.v.extract(e, file); err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", dest, err)
+	}
+
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	if e.Attr&AttrRO != 0 {
+		stat, err := os.Stat(dest)
+		if err != nil {
+			return err
+		}
+		if err = os.Chmod(dest, stat.Mode()&^0222); err != nil {
+			return err
+		}
+	}
+
+	return os.Chtimes(dest, e.Acc, e.Mod)
+}
+
+// hostPath joins dir and the name of an entry refusing names that would end
+// up outside dir
+func hostPath(dir, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("unsafe name %q inside %s", name, dir)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ImportOptions configures ImportTree
+type ImportOptions struct {
+	Recursive      bool     // import directories together with everything inside them
+	Include        []string // glob patterns, if any is given files must match one of them
+	Exclude        []string // glob patterns of files and directories left out
+	FollowSymlinks bool     // import what symlinks point to instead of skipping them
+}
+
+// ImportTree copies the file or directory src of the host into path. If path
+// is an existing directory src is copied inside it, a src ending in "/." has
+// its content copied into path instead. Patterns are matched against the
+// name and against the path relative to src. Modification times are kept and
+// files without write permission become read only. Directories need
+// opts.Recursive
+//
+// Everything is checked before the first write: names must be valid and not
+// taken and the free space must be enough
+func (v *Volume) ImportTree(src, path string, opts ImportOptions) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+
+	p := &importPlan{v: v, opts: opts}
+
+	contents := src == "." || strings.HasSuffix(src, string(filepath.Separator)+".")
+	src = filepath.Clean(src)
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+	if info.IsDir() && !opts.Recursive {
+		return ErrIsDir
+	}
+
+	dst := splitPath(path)
+	target, err := v.lookup(dst)
+	switch {
+	case len(dst) == 0:
+		// the root directory, it always exists
+		target, err = EntryInfo{Attr: AttrDir}, nil
+	case err == ErrNotFound && !contents:
+		// src takes the name of path
+		if target.Location, err = v.lookupDir(dst[:len(dst)-1]); err != nil {
+			return
+		}
+		if err = p.add(src, dst, ".", info, nil); err != nil {
+			return
+		}
+		if err = p.grow(target.Location, []string{dst[len(dst)-1]}); err != nil {
+			return
+		}
+		return p.run()
+	case err != nil:
+		return
+	}
+
+	if target.Attr&AttrDir == 0 {
+		return ErrNotDir
+	}
+
+	if contents {
+		if !info.IsDir() {
+			return ErrNotDir
+		}
+		p.items = append(p.items, importItem{src: src, path: dst, info: info, exists: true})
+		if err = p.addDir(len(p.items)-1, ".", []os.FileInfo{info}); err != nil {
+			return
+		}
+		return p.run()
+	}
+
+	dst = append(dst, filepath.Base(src))
+	if err = p.add(src, dst, ".", info, nil); err != nil {
+		return
+	}
+	if err = p.grow(target.Location, []string{filepath.Base(src)}); err != nil {
+		return
+	}
+
+	return p.run()
+}
+
+// importItem is a file or directory ImportTree copies
+type importItem struct {
+	src      string   // host path
+	path     []string // image path
+	info     os.FileInfo
+	exists   bool     // the directory is already in the image
+	children []string // names added inside the directory
+}
+
+// importPlan holds everything ImportTree will write, it's built before
+// writing anything
+type importPlan struct {
+	v        *Volume
+	opts     ImportOptions
+	items    []importItem // parents come before their content
+	clusters uint64       // free clusters needed
+}
+
+// add plans copying src, whose info is given, into dst. rel is the path of
+// src relative to the top of the import and ancestors the directories above
+// it, symlinks are checked against them so they cannot loop
+func (p *importPlan) add(src string, dst []string, rel string, info os.FileInfo, ancestors []os.FileInfo) (err error) {
+	name := dst[len(dst)-1]
+
+	if _, _, _, err = basisName(name, p.v.CodePage); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	if _, err = convNameLong(name, "           "); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	cs := uint64(p.v.Info.ClusterSize)
+
+	if !info.IsDir() {
+		if info.Size() > math.MaxUint32 {
+			return fmt.Errorf("%s: %w", src, ErrFileTooBig)
+		}
+		// a file can only be added if its parent is new or doesn't have it
+		if _, err = p.v.lookup(dst); err == nil {
+			return fmt.Errorf("%s: %w", strings.Join(dst, "/"), ErrExists)
+		} else if err != ErrNotFound && err != ErrNotDir {
+			return
+		}
+
+		p.items = append(p.items, importItem{src: src, path: dst, info: info})
+		p.clusters += (uint64(info.Size()) + cs - 1) / cs
+
+		return nil
+	}
+
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			return fmt.Errorf("%s: symlink loop", src)
+		}
+	}
+
+	item := importItem{src: src, path: dst, info: info}
+
+	switch e, err := p.v.lookup(dst); {
+	case err == nil && e.Attr&AttrDir == 0:
+		return fmt.Errorf("%s: %w", strings.Join(dst, "/"), ErrExists)
+	case err == nil:
+		item.exists = true
+	case err != ErrNotFound && err != ErrNotDir:
+		return err
+	}
+
+	p.items = append(p.items, item)
+
+	return p.addDir(len(p.items)-1, rel, append(ancestors, info))
+}
+
+// addDir plans the content of the directory items[i]
+func (p *importPlan) addDir(i int, rel string, ancestors []os.FileInfo) (err error) {
+	dir := p.items[i]
+
+	entries, err := os.ReadDir(dir.src)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(dir.src, entry.Name())
+		childRel := path.Join(rel, entry.Name())
+
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !p.opts.FollowSymlinks {
+				continue
+			}
+			if info, err = os.Stat(src); err != nil {
+				return err
+			}
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			// devices, sockets and pipes have no place in FAT
+			continue
+		}
+		if matchAny(p.opts.Exclude, entry.Name(), childRel) {
+			continue
+		}
+		if !info.IsDir() && len(p.opts.Include) != 0 && !matchAny(p.opts.Include, entry.Name(), childRel) {
+			continue
+		}
+
+		// FAT names ignore case, two host files could end up as the same
+		if slices.ContainsFunc(p.items[i].children, func(c string) bool { return strings.EqualFold(c, entry.Name()) }) {
+			return fmt.Errorf("%s: %w", src, ErrExists)
+		}
+
+		dst := append(slices.Clone(dir.path), entry.Name())
+		if err = p.add(src, dst, childRel, info, ancestors); err != nil {
+			return err
+		}
+		p.items[i].children = append(p.items[i].children, entry.Name())
+	}
+
+	dir = p.items[i]
+	if !dir.exists {
+		// "." and ".." plus the new entries
+		p.clusters += p.v.dirClusters(2 + slotsFor(dir.children))
+		return nil
+	}
+
+	if len(dir.children) == 0 {
+		return nil
+	}
+
+	cluster := uint32(0)
+	if len(dir.path) != 0 {
+		e, err := p.v.lookup(dir.path)
+		if err != nil {
+			return err
+		}
+		cluster = e.Location
+	}
+
+	return p.grow(cluster, dir.children)
+}
+
+// grow accounts for the clusters the existing directory starting at cluster
+// needs to take the entries called names
+func (p *importPlan) grow(cluster uint32, names []string) error {
+	d, err := p.v.openDir(cluster)
+	if err != nil {
+		return err
+	}
+
+	// the same room findFreeSlots will find, the entries are counted as
+	// if they were a single one
+	need := slotsFor(names)
+	index, free, _, err := p.v.scanFreeSlots(d, need)
+	if err != nil || index >= 0 {
+		return err
+	}
+	need -= free
+
+	if d.cluster == 0 {
+		// the FAT12/16 root directory cannot grow
+		return ErrDirFull
+	}
+
+	p.clusters += p.v.dirClusters(need)
+
+	return nil
+}
+
+// run checks there's room for the plan and writes it
+func (p *importPlan) run() error {
+	free, err := p.v.FreeClusters()
+	if err != nil {
+		return err
+	}
+
+	if p.clusters > uint64(free) {
+		return fmt.Errorf("%w: %d clusters needed, %d free", ErrNoSpace, p.clusters, free)
+	}
+
+	for _, item := range p.items {
+		dst := strings.Join(item.path, "/")
+
+		if item.info.IsDir() {
+			if item.exists {
+				continue
+			}
+			if err = p.v.Mkdir(dst, false); err != nil {
+				return err
+			}
+			if err = p.v.Chtimes(dst, time.Time{}, item.info.ModTime()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		attr := HexByte(AttrArchive)
+		if item.info.Mode().Perm()&0200 == 0 {
+			attr |= AttrRO
+		}
+
+		file, err := os.Open(item.src)
+		if err != nil {
+			return err
+		}
+		err = p.v.writeFile(dst, file, attr, item.info.ModTime())
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.src, err)
+		}
+	}
+
+	return nil
+}
+
+// matchAny reports whether the name or the relative path rel match any of
+// the glob patterns
+func matchAny(patterns []string, name, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// slotsFor returns how many slots the entries called names take at most,
+// every one of them with its long name
+func slotsFor(names []string) (slots int) {
+	for _, name := range names {
+		units := len(utf16.Encode([]rune(name)))
+		slots += 1 + (units+longNameChunk-1)/longNameChunk
+	}
+	return
+}
+
+// dirClusters returns the clusters needed to hold slots directory slots
+func (v *Volume) dirClusters(slots int) uint64 {
+	cs := uint64(v.Info.ClusterSize)
+	return max(1, (uint64(slots)*RootEntrySize+cs-1)/cs)
+}
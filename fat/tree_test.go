@@ -0,0 +1,164 @@
+package fat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// hostTree creates files inside dir, the keys are slash separated paths
+func hostTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImportTree(t *testing.T) {
+	src := t.TempDir()
+	hostTree(t, src, map[string]string{
+		"a.txt":          "a",
+		"sub/b.txt":      "b",
+		"sub/deep/c.log": "c",
+		"skip/d.txt":     "d",
+	})
+
+	mod := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "sub/b.txt"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	v, img := newImage(t, 16<<20, FormatOptions{})
+	v.Location = time.UTC
+
+	if err := v.ImportTree(src, "/", ImportOptions{}); err != ErrIsDir {
+		t.Fatalf("got %v, want %v", err, ErrIsDir)
+	}
+
+	err := v.ImportTree(src+string(filepath.Separator)+".", "/", ImportOptions{
+		Recursive: true,
+		Exclude:   []string{"skip", "*.log"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		b, err := v.ReadFile(name)
+		if err != nil || string(b) != content {
+			t.Errorf("%s: %q %v", name, b, err)
+		}
+	}
+	for _, name := range []string{"skip", "sub/deep/c.log"} {
+		if _, err := v.Lookup(name); err != ErrNotFound {
+			t.Errorf("%s: got %v, want %v", name, err, ErrNotFound)
+		}
+	}
+	if _, err := v.Lookup("sub/deep"); err != nil {
+		t.Error(err)
+	}
+
+	if e, _ := v.Lookup("a.txt"); !e.Mod.Equal(mod) {
+		t.Errorf("a.txt modified at %v, want %v", e.Mod, mod)
+	}
+	if e, _ := v.Lookup("sub/b.txt"); e.Attr&AttrRO == 0 {
+		t.Errorf("sub/b.txt attributes %v, want read only", e.Attr)
+	}
+
+	// a second import clashes with the first one and writes nothing
+	before := bytes.Clone(img)
+	if err := v.ImportTree(src+string(filepath.Separator)+".", "/", ImportOptions{Recursive: true}); !errors.Is(err, ErrExists) {
+		t.Fatalf("got %v, want %v", err, ErrExists)
+	}
+	if !bytes.Equal(before, img) {
+		t.Fatal("failed import changed the image")
+	}
+}
+
+func TestImportTreeNoSpace(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{}
+	for i := range 20 {
+		files[fmt.Sprintf("dir/file with a long name %d", i)] = "x"
+	}
+	files["big"] = string(make([]byte, 1<<20))
+	hostTree(t, src, files)
+
+	// too big for the clusters left
+	v, img := newImage(t, 1440<<10, FormatOptions{})
+	if err := v.WriteFile("filler", bytes.NewReader(make([]byte, 800<<10))); err != nil {
+		t.Fatal(err)
+	}
+
+	before := bytes.Clone(img)
+	if err := v.ImportTree(src, "/", ImportOptions{Recursive: true}); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("got %v, want %v", err, ErrNoSpace)
+	}
+	if !bytes.Equal(before, img) {
+		t.Fatal("failed import changed the image")
+	}
+
+	// too many names for the fixed root directory
+	v, img = newImage(t, 16<<20, FormatOptions{RootEntries: 16})
+
+	before = bytes.Clone(img)
+	err := v.ImportTree(filepath.Join(src, "dir")+string(filepath.Separator)+".", "/", ImportOptions{Recursive: true})
+	if err != ErrDirFull {
+		t.Fatalf("got %v, want %v", err, ErrDirFull)
+	}
+	if !bytes.Equal(before, img) {
+		t.Fatal("failed import changed the image")
+	}
+
+	// a subdirectory grows instead
+	if err = v.ImportTree(filepath.Join(src, "dir"), "/", ImportOptions{Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := v.List("dir")
+	if err != nil || len(entries) != 20 {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+}
+
+func TestExtractTree(t *testing.T) {
+	v, _ := newImage(t, 16<<20, FormatOptions{})
+
+	if err := v.Mkdir("d/e", true); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		content := bytes.Repeat([]byte{byte(i)}, i*1000)
+		if err := v.WriteFile(fmt.Sprintf("d/e/f%d", i), bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := t.TempDir()
+	if err := v.ExtractTree("d", dst, ExtractOptions{}); err != ErrIsDir {
+		t.Fatalf("got %v, want %v", err, ErrIsDir)
+	}
+	if err := v.ExtractTree("d", dst, ExtractOptions{Recursive: true, Parallel: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 20 {
+		b, err := os.ReadFile(filepath.Join(dst, "d", "e", fmt.Sprintf("f%d", i)))
+		if err != nil || !bytes.Equal(b, bytes.Repeat([]byte{byte(i)}, i*1000)) {
+			t.Fatalf("f%d: %v", i, err)
+		}
+	}
+}
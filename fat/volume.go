@@ -0,0 +1,300 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Volume is an opened FAT filesystem
+type Volume struct {
+	BPB   BPB
+	Ext16 BPBExt16
+	Ext32 BPBExt32
+	Info  FATInfo
+
+	// FSInfo is only read on FAT32 volumes
+	FSInfo FSInfo
+
+	// CodePage is used to read and write short names and the volume label
+	CodePage CodePage
+
+	// Location is the time zone timestamps are stored in, FAT keeps local
+	// time. time.Local is used if nil
+	Location *time.Location
+
+	r io.ReaderAt
+	w io.WriterAt // nil when the volume is read only
+	c io.Closer   // set when the volume owns the underlying file
+}
+
+// Open opens the FAT image at path. If the image cannot be opened for writing
+// it is opened read only. Whole disk images are opened on their first FAT
+// partition
+func Open(path string) (*Volume, error) {
+	return OpenPartition(path, "")
+}
+
+// OpenPartition opens the partition of the disk image at path selected by sel
+// as FindPartition does. An empty sel works like Open
+func OpenPartition(path, sel string) (*Volume, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := newPartition(file, sel)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	v.c = file
+
+	return v, nil
+}
+
+// newPartition returns the volume inside the partition selected by sel. An
+// empty sel takes the whole image or, if it isn't a FAT volume, the first
+// partition holding one
+func newPartition(r io.ReaderAt, sel string) (*Volume, error) {
+	if sel == "" {
+		v, err := New(r)
+		if err != ErrNotFAT {
+			return v, err
+		}
+
+		parts, e := Partitions(r)
+		if e != nil {
+			return nil, err
+		}
+
+		for _, p := range parts {
+			if v, e := NewPartition(r, p); e == nil {
+				return v, nil
+			}
+		}
+
+		return nil, err
+	}
+
+	parts, err := Partitions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := FindPartition(parts, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPartition(r, p)
+}
+
+// New reads the reserved region from r and returns the volume described by it.
+// If r also implements io.WriterAt the volume can be modified
+func New(r io.ReaderAt) (*Volume, error) {
+	w, _ := r.(io.WriterAt)
+	return newVolume(r, w)
+}
+
+func newVolume(r io.ReaderAt, w io.WriterAt) (v *Volume, err error) {
+	v = &Volume{r: r, w: w}
+
+	v.BPB, v.Ext16, v.Ext32, v.Info, err = readReservedSector(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Info.Type == FAT32 {
+		if err = v.readFSInfo(); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// Close closes the underlying file if the volume was created with Open
+func (v *Volume) Close() error {
+	if v.c == nil {
+		return nil
+	}
+	return v.c.Close()
+}
+
+// ReadOnly reports whether the volume can be modified
+func (v *Volume) ReadOnly() bool {
+	return v.w == nil
+}
+
+func readReservedSector(r io.Reader) (
+	bpb BPB,
+	ext16 BPBExt16,
+	ext32 BPBExt32,
+	info FATInfo,
+	err error,
+) {
+	if err = binary.Read(r, binary.LittleEndian, &bpb); err != nil {
+		return
+	}
+
+	if !doILookFAT(bpb) {
+		err = ErrNotFAT
+		return
+	}
+
+	// TODO: check this calculation RootEntrySize is set to 32 bytes but that would be only in FAT32
+	// also RootEntryCount is zero in FAT32 DOUBLE CHECK!
+	info.RootDirSectors = (uint32(bpb.RootEntryCount)*RootEntrySize +
+		uint32(bpb.BytesPerSector) - 1) / uint32(bpb.BytesPerSector)
+
+	// root entry count greater than 0 usually means FAT12/16
+	if bpb.RootEntryCount != 0 {
+		if err = binary.Read(r, binary.LittleEndian, &ext16); err != nil {
+			return
+		}
+	} else { // if root entry count is 0 the type is FAT32
+		if err = binary.Read(r, binary.LittleEndian, &ext32); err != nil {
+			return
+		}
+		// set fat type
+		info.Type = FAT32
+	}
+
+	// save sector size
+	info.SectorSize = uint32(bpb.BytesPerSector)
+
+	// save number of FAT entries
+	info.FATNumber = uint32(bpb.NFATs)
+
+	// calculate total number of sectors for volume
+	if bpb.TotalSectors16 != 0 {
+		info.TotalSectors = uint32(bpb.TotalSectors16)
+	} else {
+		info.TotalSectors = bpb.TotalSectors32
+	}
+
+	// calculate number of sectors per FAT entry
+	if bpb.FATsz16 != 0 {
+		info.FATSectors = uint32(bpb.FATsz16)
+	} else {
+		info.FATSectors = ext32.FATsz32
+	}
+
+	// this formula is used to get the total count of clusters in the partition
+	// then use it to determinate the FAT type as follows
+	// if clusterCount < 4085       = FAT12
+	// else if clusterCount < 65525 = FAT16
+	// else                         = FAT32
+	// for some reason you can create different FAT types disregarding
+	// cluster count when using mkfs.fat on linux
+	// that's why I tried another method to figure out FAT type checking root dir sector count
+	// I'm not sure if it is correct
+	info.DataSectors = info.TotalSectors - (uint32(bpb.ReservedSectorCount) +
+		uint32(bpb.NFATs)*info.FATSectors +
+		uint32(info.RootDirSectors))
+
+	info.ClusterCount = info.DataSectors / uint32(bpb.SectorPerCluster)
+
+	// calculate cluster size in bytes
+	info.ClusterSize = uint32(bpb.SectorPerCluster) * uint32(bpb.BytesPerSector)
+
+	// set FAT type by cluster count or set a warning if the type mismatch
+	switch ccnt := info.ClusterCount; {
+	case ccnt < 4085:
+		if info.Type == 0 {
+			info.Type = FAT12
+		} else {
+			info.Warning = fmt.Sprintf(
+				"according to my calculations FAT type is %d but the cluster count point to FAT12",
+				info.Type,
+			)
+		}
+	case ccnt < 65525:
+		if info.Type == 0 {
+			info.Type = FAT16
+		} else {
+			info.Warning = fmt.Sprintf(
+				"according to my calculations FAT type is %d but the cluster count point to FAT16",
+				info.Type,
+			)
+		}
+	default:
+		if info.Type == 0 || info.Type == FAT32 {
+			info.Type = FAT32
+		} else {
+			info.Warning = fmt.Sprintf(
+				"according to my calculations FAT type is %d but the cluster count point to FAT32",
+				info.Type,
+			)
+		}
+	}
+
+	// calculate offsets
+	switch info.Type {
+	case FAT12, FAT16:
+		info.RootDirOffset = (uint32(bpb.ReservedSectorCount) + info.FATNumber*info.FATSectors) *
+			uint32(bpb.BytesPerSector)
+	case FAT32:
+		info.RootDirOffset = (uint32(bpb.ReservedSectorCount) +
+			info.FATNumber*info.FATSectors +
+			(ext32.RootCluster-2)*uint32(bpb.SectorPerCluster)) * uint32(bpb.BytesPerSector)
+	}
+
+	info.FATOffset = uint32(bpb.ReservedSectorCount) * uint32(bpb.BytesPerSector)
+	info.DataOffset = (uint32(bpb.ReservedSectorCount) +
+		info.FATNumber*info.FATSectors + info.RootDirSectors) *
+		uint32(bpb.BytesPerSector)
+
+	return
+}
+
+// doILookFAT checks if it's an actual FAT filesystem. Some MBR boot code
+// starts with a jump too so the BPB fields used for the layout are checked
+func doILookFAT(bpb BPB) bool {
+	switch bpb.JumpBoot[0] {
+	case 0xEB, 0xE9:
+	default:
+		return false
+	}
+
+	switch bpb.BytesPerSector {
+	case 512, 1024, 2048, 4096:
+	default:
+		return false
+	}
+
+	spc := bpb.SectorPerCluster
+	return spc != 0 && spc&(spc-1) == 0 && bpb.NFATs != 0 && bpb.ReservedSectorCount != 0
+}
+
+// readAt decodes data from the volume at offset
+func (v *Volume) readAt(offset int64, data any) (err error) {
+	return binary.Read(io.NewSectionReader(v.r, offset, math.MaxInt64-offset), binary.LittleEndian, data)
+}
+
+// readBytes reads len(p) bytes at offset straight into p, without the
+// extra copy readAt does
+func (v *Volume) readBytes(offset int64, p []byte) error {
+	// a reader at the end of its data may return io.EOF with everything read
+	if n, err := v.r.ReadAt(p, offset); n < len(p) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// writeAt encodes data into the volume at offset
+func (v *Volume) writeAt(offset int64, data any) (err error) {
+	if v.w == nil {
+		return ErrReadOnly
+	}
+	return binary.Write(io.NewOffsetWriter(v.w, offset), binary.LittleEndian, data)
+}
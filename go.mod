@@ -1,3 +1,5 @@
 module github.com/argot42/lookfat
 
 go 1.24.4
+
+require golang.org/x/text v0.34.0
@@ -1,276 +1,77 @@
 package main
 
 import (
-	"encoding/binary"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"path/filepath"
-	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-)
-
-// printables
-const HexFMT = "0x%x"
-
-type HexByte uint8
-
-func (b HexByte) String() string {
-	return fmt.Sprintf(HexFMT, uint8(b))
-}
-
-type Hex2Byte [2]uint8
-
-func (b Hex2Byte) String() string {
-	var str string
-	for i, s := range b {
-		if i != 0 {
-			str += " "
-		}
-		str += fmt.Sprint(HexByte(s))
-	}
-	return fmt.Sprintf("|%s|", str)
-}
-
-type Hex3Byte [3]uint8
-
-func (b Hex3Byte) String() string {
-	var str string
-	for i, s := range b {
-		if i != 0 {
-			str += " "
-		}
-		str += fmt.Sprint(HexByte(s))
-	}
-	return fmt.Sprintf("|%s|", str)
-}
-
-type Str4Byte [4]uint8
-
-func (b Str4Byte) String() string {
-	var buf []byte
-	for _, s := range b {
-		buf = append(buf, byte(s))
-	}
-	return fmt.Sprintf("\"%s\"", string(buf))
-}
-
-type Str8Byte [8]uint8
-
-func (b Str8Byte) String() string {
-	var buf []byte
-	for _, s := range b {
-		buf = append(buf, byte(s))
-	}
-	return fmt.Sprintf("\"%s\"", string(buf))
-}
-
-type Str10Byte [10]uint8
-
-func (b Str10Byte) String() string {
-	var buf []byte
-	for _, s := range b {
-		buf = append(buf, byte(s))
-	}
-	return fmt.Sprintf("\"%s\"", string(buf))
-}
-
-type Str11Byte [11]uint8
-
-func (b Str11Byte) String() string {
-	var buf []byte
-	for _, s := range b {
-		buf = append(buf, byte(s))
-	}
-	return fmt.Sprintf("\"%s\"", string(buf))
-}
-
-type Str12Byte [12]uint8
-
-func (b Str12Byte) String() string {
-	var buf []byte
-	for _, s := range b {
-		buf = append(buf, byte(s))
-	}
-	return fmt.Sprintf("\"%s\"", string(buf))
-}
-
-// FAT header
-type BPB struct {
-	JumpBoot            Hex3Byte
-	OEMName             Str8Byte
-	BytesPerSector      uint16
-	SectorPerCluster    uint8
-	ReservedSectorCount uint16
-	NFATs               uint8
-	RootEntryCount      uint16
-	TotalSectors16      uint16
-	Media               HexByte
-	FATsz16             uint16 // number of sectors per FAT
-	SectorPerTrack      uint16
-	NumberHeads         uint16
-	HiddenSectors       uint32
-	TotalSectors32      uint32
-}
-
-type BPBExt16 struct {
-	DriveNumber   uint8
-	Reserved      uint8
-	BootSignature uint8
-	VolumenID     uint32
-	VolumenLabel  Str11Byte
-	FSType        Str8Byte
-	Empty         [448]uint8
-	SignatureWord Hex2Byte
-}
-
-type BPBExt32 struct {
-	FATsz32  uint32 // number of sectors per FAT (FAT32 only)
-	ExtFlags [2]uint8
-	FSVer    [2]uint8
-	// RootCluster is the cluster number where the root directory begins inside the data region
-	RootCluster   uint32
-	FSInfo        uint16
-	BkBootSec     uint16
-	Reserved      [12]uint8
-	DriveNum      uint8
-	Reserved1     uint8
-	BootSignature uint8
-	VolumenID     uint32
-	VolumenLabel  Str11Byte
-	FSType        Str8Byte
-	Empty         [420]uint8
-	SignatureWord Hex2Byte
-}
-
-// FATInfo is a helper struct that saves useful information calculated with headers' info
-type FATInfo struct {
-	Type           uint8
-	Warning        string
-	FATNumber      uint32
-	FATSectors     uint32
-	FATOffset      uint32
-	RootDirSectors uint32
-	RootDirOffset  uint32
-	DataSectors    uint32
-	DataOffset     uint32
-	TotalSectors   uint32
-	SectorSize     uint32
-	ClusterCount   uint32
-	ClusterSize    uint32
-}
-
-// dir/file entries
-type DirEntry struct {
-	Name    Str11Byte
-	Attr    HexByte
-	NTRes   uint8  // reserved must be 0?
-	CTTenth uint8  // creation time. count tenths of a second 0 <= CCTenth <= 199
-	CTime   uint16 // creation time. granularity is 2s
-	CDate   uint16 // creation date
-	// last accessed date.
-	// This field must be updated on file modification (write operation)
-	// and the date value must be equal to WDate.
-	LDate uint16
-	// High word of first data cluster number for file/directory described by this entry.
-	// Only valid for volumes formatted FAT32. Must be set to 0 on volumes formatted FAT12/FAT16.
-	FirstClusterHI uint16
-	WTime          uint16 // write time (must be equal to CTime at creation)
-	WDate          uint16 // write date (must be equal to CDate at creation)
-	// Low word of first data cluster number for file/dir described by this entry
-	FirstClusterLO uint16
-	FileSize       uint32
-}
 
-type DirEntryLong struct {
-	// order of the long name entry. the contents of the fields must be masked with 0x40
-	Ordinal HexByte
-	// for the last long directory name in the set
-	Name1          Str10Byte // first 5 chars in name
-	Attr           HexByte
-	Type           uint8 // Reserved (set to 0)
-	Checksum       uint8
-	Name2          Str12Byte // 6 more chars in name
-	FirstClusterLO uint16    // must be set to 0
-	Name3          Str4Byte  // last 2 chars in name
-}
-
-type EntryInfo struct {
-	ShortName string
-	LongName  string
-	Attr      HexByte
-	Crt       time.Time
-	Mod       time.Time
-	Location  uint32
-	Size      uint32
-}
-
-// legal file attributes
-const (
-	AttrRO     = 0x01 // file cannot be modified - all modification requests should fail
-	AttrHidden = 0x02 // the file or subdir should not be listed unless explicitly requested
-	AttrSystem = 0x04 // same as above but the request should be about "system files"
-	// The corresponding entry contains the volume
-	// label. DIR_FstClusHI and DIR_FstClusLO
-	// must always be 0 for the corresponding entry
-	// (representing the volume label) since no
-	// clusters can be allocated for this entry.
-	// Only the root directory (see Section 6.x below)
-	// can contain one entry with this attribute. No
-	// sub-directory must contain an entry of this type.
-	// Entries representing long file names (see
-	// Section 7) are exceptions to these rules.
-	AttrVolID = 0x08
-	// The corresponding entry represents a directory
-	// (a child or sub-directory to the containing
-	// directory).
-	// DIR_FileSize for the corresponding entry
-	// must always be 0 (even though clusters may
-	// have been allocated for the directory).
-	AttrDir = 0x10
-	// documentation says this should be set when when the file is
-	// created, renamed, or modified but it seems to be used for regular files, idk
-	AttrArchive  = 0x20
-	AttrLongName = AttrRO | AttrHidden | AttrSystem | AttrVolID // long filename entry
-
-	AttrEnd = 0x0 // not a real attribute
-)
-
-// long filename
-const (
-	LastEntryLong = 0x40
+	"github.com/argot42/lookfat/fat"
 )
 
-const (
-	FAT12 = iota
-	FAT16
-	FAT32
-)
-
-const RootEntrySize = 32
-
 type Flags struct {
 	printReserved bool
 	printRoot     bool
 	printType     bool
 	printInfo     bool
 	printFAT      bool
+	recount       bool
 	filename      string
 	name          string
 }
 
+// command is a subcommand, it returns the exit status
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"fsck": {fsck, "fsck [-P partition] [-repair] [-lost] image"},
+		"get":  {get, "get [-P partition] [-r] [-hidden] [-system] [-j jobs] image path dest"},
+		"mkfs": {mkfs, "mkfs [-P partition] [-C size] [-F 12|16|32] [-S sector size] [-s sectors per cluster] " +
+			"[-R reserved] [-f fats] [-r root entries] [-M media] [-O oem] [-n label] [-i serial] image"},
+		"mkdir":    {mkdir, "mkdir [-P partition] [-p] image path..."},
+		"mv":       {mv, "mv [-P partition] [-f] image source target"},
+		"parts":    {parts, "parts image"},
+		"put":      {put, "put [-P partition] [-r] [-L] [-include glob]... [-exclude glob]... image source path"},
+		"rm":       {rm, "rm [-P partition] [-r] [-secure] image path..."},
+		"rmdir":    {rmdir, "rmdir [-P partition] image path..."},
+		"truncate": {truncate, "truncate [-P partition] image path size"},
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	flag.Usage = usage
+
 	printHelp := flag.Bool("h", false, "print usage")
 	printReserved := flag.Bool("r", false, "print reserved region")
 	printRoot := flag.Bool("d", false, "print root directory region")
 	printType := flag.Bool("t", false, "detect FAT type")
 	printInfo := flag.Bool("i", false, "print fs info")
 	printFAT := flag.Bool("a", false, "print all FAT entries")
+	recount := flag.Bool("s", false, "recount free clusters into the FAT32 FSInfo sector")
 	filename := flag.String("f", "", "get content from file")
 	name := flag.String("w", "", "write stdin to file")
+	mode := flag.String("m", "create", "how -w writes the file: create, overwrite or append")
+	partition := flag.String("P", "", "partition of a whole disk image (index, GPT name or GUID)")
+	cp := flag.String("cp", "437", "OEM code page of short names and the volume label")
+	tz := flag.String("tz", "Local", "time zone of the timestamps stored in the image")
+	mtime := flag.String("T", "", "modification time of the file written with -w (RFC 3339 or 2006-01-02 15:04:05)")
 
 	flag.Parse()
 
@@ -280,6 +81,7 @@ func main() {
 		printType:     *printType,
 		printInfo:     *printInfo,
 		printFAT:      *printFAT,
+		recount:       *recount,
 		filename:      *filename,
 		name:          *name,
 	}
@@ -301,1016 +103,565 @@ func main() {
 
 	filepath := flag.Arg(0)
 
-	file, err := os.OpenFile(filepath, os.O_RDWR, os.ModeType)
+	v, err := fat.OpenPartition(filepath, *partition)
 	checkerr("", err)
-	defer file.Close()
+	defer v.Close()
 
-	bpb, ext16, ext32, info, err := readReservedSector(file)
-	checkerr("", err)
+	v.CodePage = codePage(*cp)
+	v.Location = location(*tz)
 
-	root, err := readDir(file, int64(info.RootDirOffset))
+	root, err := v.Root()
 	checkerr("", err)
 
 	if flags.printReserved {
-		pReserved(bpb, ext16, ext32, info)
+		pReserved(v)
 	}
 	if flags.printRoot {
-		pRoot(info, root)
+		pRoot(v, root)
 	}
 	if flags.printType {
-		pType(info)
+		pType(v.Info)
 	}
 	if flags.printInfo {
-		pInfo(info)
+		pInfo(v)
+	}
+	if flags.recount {
+		err = v.RecountFree()
+		checkerr("", err)
 	}
 	if flags.printFAT {
-		pFAT(file, info)
+		err = pFAT(v)
+		checkerr("", err)
 	}
 	if flags.filename != "" {
-		err = pFile(file, flags.filename, bpb, info, root)
+		err = pFile(v, flags.filename)
 		checkerr("", err)
 	}
 	if flags.name != "" {
-		err = wFile(file, os.Stdin, flags.name, bpb, info, root)
+		// the time is checked before anything is written
+		var mod time.Time
+		if *mtime != "" {
+			mod, err = parseTime(*mtime, v.Location)
+			checkerr("-T", err)
+		}
+
+		err = wFile(v, flags.name, *mode, mod)
 		checkerr("", err)
 	}
 }
 
-func wFile(
-	file *os.File,
-	input io.Reader,
-	name string,
-	bpb BPB,
-	info FATInfo,
-	_ []EntryInfo,
-) (err error) {
-	location, err := findEmptyFAT(file, 3, info)
-	if err != nil {
-		return
-	}
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] image\n", os.Args[0])
+	flag.PrintDefaults()
 
-	shortName, err := convNameShort(name)
-	if err != nil {
-		return
+	fmt.Fprintln(flag.CommandLine.Output(), "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	fileEntry := EntryInfo{
-		ShortName: string(shortName),
-		LongName:  name,
-		Attr:      0x20,
-		Location:  location,
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s %s\n", os.Args[0], commands[name].usage)
 	}
+}
 
-	eof, fatEntry := mkentry(info.Type)
-	chunk := make([]byte, info.ClusterSize)
-
-	for {
-		// read from out input file into buffer
-		n, inputErr := io.ReadFull(input, chunk)
-		if inputErr != nil && inputErr != io.ErrUnexpectedEOF {
-			return inputErr
-		}
-		chunk = chunk[:n]
-		fileEntry.Size += uint32(n)
-
-		// calculate file offset inside file region
-		fileOffset := getFileOffset(location, bpb, info)
-
-		// write into FS
-		if err = writeAt(file, int64(fileOffset), chunk); err != nil {
-			return
-		}
+// subcommand parses args with fset and opens the image given as the first
+// argument left. The arguments after the image are returned, there must be
+// between minArgs and maxArgs of them (no limit if maxArgs is negative)
+func subcommand(fset *flag.FlagSet, usage string, args []string, minArgs, maxArgs int) (*fat.Volume, []string) {
+	cp := fset.String("cp", "437", "OEM code page of short names and the volume label")
+	tz := fset.String("tz", "Local", "time zone of the timestamps stored in the image")
 
-		if inputErr == io.ErrUnexpectedEOF {
-			// this means we reached the EOF and we have to write the last
-			// entry into the FAT region
-			putLocToEntry(info.Type, fatEntry, eof)
+	path, partition, rest := parseSubcommand(fset, usage, args, minArgs, maxArgs)
 
-			fatEntryOffset := getFATEntryOffset(location, len(fatEntry), info)
+	v, err := fat.OpenPartition(path, partition)
+	checkerr("", err)
 
-			if err = writeAt(file, fatEntryOffset, fatEntry); err != nil {
-				return
-			}
+	v.CodePage = codePage(*cp)
+	v.Location = location(*tz)
 
-			break
-		} else {
-			// file is not entirely read yeat so we find new empty fat entry
-			// we save it into our old location and continue with our new location
-			oldLoc := location
-
-			location, err = findEmptyFAT(file, location+1, info)
-			if err != nil {
-				return
-			}
+	return v, rest
+}
 
-			putLocToEntry(info.Type, fatEntry, location)
+// codePage returns the code page called name or exits if it's unknown
+func codePage(name string) fat.CodePage {
+	cp, err := fat.LookupCodePage(name)
+	checkerr("", err)
+	return cp
+}
 
-			fatEntryOffset := getFATEntryOffset(oldLoc, len(fatEntry), info)
+// location returns the time zone called name or exits if it's unknown
+func location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	checkerr("time zone", err)
+	return loc
+}
 
-			if err = writeAt(file, fatEntryOffset, fatEntry); err != nil {
-				return
-			}
-		}
+// parseTime parses a time given as RFC 3339 or as a date with an optional
+// time of the day in loc
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
 	}
-
-	// lastly we add file entry to root region
-	if err = addFile(file, info, fileEntry); err != nil {
-		return
+	if t, err := time.ParseInLocation(time.DateTime, s, loc); err == nil {
+		return t, nil
 	}
-
-	return
+	return time.ParseInLocation(time.DateOnly, s, loc)
 }
 
-func pFAT(file *os.File, info FATInfo) (err error) {
-	_, entry := mkentry(info.Type)
-
-	if _, err = file.Seek(int64(info.FATOffset), io.SeekStart); err != nil {
-		return
-	}
-
-	pos := info.FATOffset
-	max := info.FATOffset + info.FATSectors*info.FATNumber*info.SectorSize
+// parseSubcommand parses args with fset and returns the image, the
+// partition selected with -P and the arguments after the image
+func parseSubcommand(fset *flag.FlagSet, usage string, args []string, minArgs, maxArgs int) (path, partition string, rest []string) {
+	fset.StringVar(&partition, "P", "", "partition of a whole disk image (index, GPT name or GUID)")
 
-	var i int
-	for pos < max {
-		err := binary.Read(file, binary.LittleEndian, entry)
-		if err != nil {
-			return err
-		}
-
-		fmt.Printf("(%d: 0x%x) %v -> %v\n", i, pos, entry, locFromEntry(info.Type, entry))
-
-		pos += uint32(len(entry))
-		i++
-	}
+	path, rest = parseArgs(fset, usage, args, minArgs, maxArgs)
 
 	return
 }
 
-func pFile(file *os.File, path string, bpb BPB, info FATInfo, root []EntryInfo) (err error) {
-	splited := splitPath(path)
-	filename := splited[len(splited)-1]
-
-	for _, p := range splited {
-		root, err = walk(file, bpb, info, root, p)
-		if err != nil {
-			return
-		}
+// parseArgs parses args with fset and returns the image and the arguments
+// after it
+func parseArgs(fset *flag.FlagSet, usage string, args []string, minArgs, maxArgs int) (path string, rest []string) {
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: %s %s\n", os.Args[0], usage)
+		fset.PrintDefaults()
 	}
 
-	ok, fileInfo := findFile(filename, root)
-	if !ok {
-		return errors.New("entry not found")
-	}
-
-	var fatEntry []byte
-	var eof, location, entrySize uint32
-
-	eof, fatEntry = mkentry(info.Type)
-
-	location = fileInfo.Location
-	entrySize = uint32(len(fatEntry))
-
-	var b []byte
+	fset.Parse(args)
 
-	for {
-		// get file offset inside the file region
-		fileOffset := getFileOffset(location, bpb, info)
-
-		// buffer to store parts of the file stored inside the fs cluster
-		chunk := make([]byte, info.ClusterSize)
-
-		// read the cluster chunk
-		if _, err = file.Seek(int64(fileOffset), io.SeekStart); err != nil {
-			return
-		}
-		if err = binary.Read(file, binary.LittleEndian, chunk); err != nil {
-			return
-		}
+	if n := fset.NArg() - 1; n < minArgs || maxArgs >= 0 && n > maxArgs {
+		fset.Usage()
+		os.Exit(-1)
+	}
 
-		b = append(b, chunk...)
+	return fset.Arg(0), fset.Args()[1:]
+}
 
-		// calculate fat entry offset (to look for next file part)
-		fatEntryOffset := info.FATOffset + location*entrySize
+func fsck(args []string) int {
+	fset := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fset.Bool("repair", false, "repair the problems found")
+	lost := fset.Bool("lost", false, "save lost chains as FOUND.000/FILE0000.CHK instead of freeing them")
 
-		// read fat entry
-		if _, err = file.Seek(int64(fatEntryOffset), io.SeekStart); err != nil {
-			return
-		}
-		if err = binary.Read(file, binary.LittleEndian, fatEntry); err != nil {
-			return
-		}
+	v, _ := subcommand(fset, commands["fsck"].usage, args, 0, 0)
+	defer v.Close()
 
-		location = locFromEntry(info.Type, fatEntry)
+	report, err := v.Check(fat.CheckOptions{Repair: *repair, SaveLost: *lost})
+	checkerr("", err)
 
-		// if the new location is EOF stop reading
-		if location == eof {
-			break
-		}
+	for _, p := range report.Problems {
+		fmt.Println(p)
 	}
 
-	err = binary.Write(os.Stdout, binary.LittleEndian, b[:fileInfo.Size])
+	status := report.Status()
+	fmt.Println(status)
 
-	return
+	return int(status)
 }
 
-func pReserved(bpb BPB, ext16 BPBExt16, ext32 BPBExt32, info FATInfo) {
-	fmt.Printf("reserved: %+v\n", bpb)
+func mkfs(args []string) int {
+	fset := flag.NewFlagSet("mkfs", flag.ExitOnError)
+	create := fset.String("C", "", "create the image with this size (K, M and G suffixes allowed)")
+	bits := fset.Int("F", 0, "FAT type (12, 16 or 32), picked from the size by default")
+	sectorSize := fset.Uint("S", 512, "bytes per sector")
+	spc := fset.Uint("s", 0, "sectors per cluster, picked from the size by default")
+	reserved := fset.Uint("R", 0, "reserved sectors")
+	nfats := fset.Uint("f", 2, "number of FATs")
+	rootEntries := fset.Uint("r", 0, "root directory entries (FAT12/16)")
+	media := fset.Uint("M", 0xf8, "media byte")
+	oem := fset.String("O", "MSWIN4.1", "OEM name")
+	label := fset.String("n", "", "volume label")
+	serial := fset.String("i", "", "volume serial number in hex, taken from the clock by default")
+	cp := fset.String("cp", "437", "OEM code page of the volume label")
+	tz := fset.String("tz", "Local", "time zone of the volume label timestamp")
 
-	switch info.Type {
-	case FAT12, FAT16:
-		fmt.Printf("ext12/16: %+v\n", ext16)
-	case FAT32:
-		fmt.Printf("ext32: %+v\n", ext32)
-	}
-}
+	path, partition, _ := parseSubcommand(fset, commands["mkfs"].usage, args, 0, 0)
 
-func pRoot(_ FATInfo, root []EntryInfo) {
-	fmt.Println("files in root dir:")
-	for _, v := range root {
-		fmt.Printf("%+v\n", v)
+	opts := fat.FormatOptions{
+		FATBits:           *bits,
+		SectorSize:        uint16(*sectorSize),
+		SectorsPerCluster: uint8(*spc),
+		ReservedSectors:   uint16(*reserved),
+		NFATs:             uint8(*nfats),
+		RootEntries:       uint16(*rootEntries),
+		Media:             uint8(*media),
+		OEMName:           *oem,
+		Label:             *label,
+		CodePage:          codePage(*cp),
+		Location:          location(*tz),
 	}
-}
 
-func pType(info FATInfo) {
-	switch info.Type {
-	case FAT12:
-		fmt.Println("FAT12")
-	case FAT16:
-		fmt.Println("FAT16")
-	case FAT32:
-		fmt.Println("FAT32")
+	if *serial != "" {
+		id, err := strconv.ParseUint(strings.ReplaceAll(*serial, "-", ""), 16, 32)
+		checkerr("serial", err)
+		opts.Serial = uint32(id)
 	}
-}
-
-func pInfo(info FATInfo) {
-	fmt.Printf(`FAT Quantity: %d
-FAT Region Sectors: %d
-FAT Region offset: 0x%x
-Root Region Sectors: %d
-Root Region offset: 0x%x
-Data Region Sectors: %d
-Data Region offset: 0x%x
-Total Sectors: %d
-Cluster Count: %d
-Cluster Size: %d
-Sector Size: %d
-`,
-		info.FATNumber,
-		info.FATSectors,
-		info.FATOffset,
-		info.RootDirSectors,
-		info.RootDirOffset,
-		info.DataSectors,
-		info.DataOffset,
-		info.TotalSectors,
-		info.ClusterCount,
-		info.ClusterSize,
-		info.SectorSize,
-	)
 
-	if info.Warning != "" {
-		fmt.Printf("-----------------------------------\nWarn: %s\n", info.Warning)
+	flags := os.O_RDWR
+	if *create != "" {
+		flags |= os.O_CREATE | os.O_EXCL
 	}
-}
 
-func readReservedSector(file *os.File) (
-	bpb BPB,
-	ext16 BPBExt16,
-	ext32 BPBExt32,
-	info FATInfo,
-	err error,
-) {
-	if err = binary.Read(file, binary.LittleEndian, &bpb); err != nil {
-		return
-	}
+	file, err := os.OpenFile(path, flags, 0644)
+	checkerr("", err)
+	defer file.Close()
 
-	if !doILookFAT(bpb) {
-		err = errors.New("not a msdos FAT FS")
-		return
+	var size int64
+	if *create != "" {
+		size, err = parseSize(*create)
+		checkerr("size", err)
+		checkerr("", file.Truncate(size))
+	} else {
+		stat, err := file.Stat()
+		checkerr("", err)
+		size = stat.Size()
 	}
 
-	// TODO: check this calculation RootEntrySize is set to 32 bytes but that would be only in FAT32
-	// also RootEntryCount is zero in FAT32 DOUBLE CHECK!
-	info.RootDirSectors = (uint32(bpb.RootEntryCount)*RootEntrySize +
-		uint32(bpb.BytesPerSector) - 1) / uint32(bpb.BytesPerSector)
+	if partition == "" {
+		checkerr("", fat.Format(file, size, opts))
 
-	// root entry count greater than 0 usually means FAT12/16
-	if bpb.RootEntryCount != 0 {
-		if err = binary.Read(file, binary.LittleEndian, &ext16); err != nil {
-			return
-		}
-	} else { // if root entry count is 0 the type is FAT32
-		if err = binary.Read(file, binary.LittleEndian, &ext32); err != nil {
-			return
-		}
-		// set fat type
-		info.Type = FAT32
+		v, err := fat.New(file)
+		checkerr("", err)
+		pType(v.Info)
+
+		return 0
 	}
 
-	// save sector size
-	info.SectorSize = uint32(bpb.BytesPerSector)
+	partitions, err := fat.Partitions(file)
+	checkerr("", err)
+	p, err := fat.FindPartition(partitions, partition)
+	checkerr("", err)
 
-	// save number of FAT entries
-	info.FATNumber = uint32(bpb.NFATs)
+	opts.HiddenSectors = uint32(p.Offset / int64(opts.SectorSize))
+	checkerr("", fat.Format(io.NewOffsetWriter(file, p.Offset), p.Size, opts))
 
-	// calculate total number of sectors for volume
-	if bpb.TotalSectors16 != 0 {
-		info.TotalSectors = uint32(bpb.TotalSectors16)
-	} else {
-		info.TotalSectors = bpb.TotalSectors32
-	}
-
-	// calculate number of sectors per FAT entry
-	if bpb.FATsz16 != 0 {
-		info.FATSectors = uint32(bpb.FATsz16)
-	} else {
-		info.FATSectors = ext32.FATsz32
-	}
+	v, err := fat.NewPartition(file, p)
+	checkerr("", err)
+	pType(v.Info)
 
-	// this formula is used to get the total count of clusters in the partition
-	// then use it to determinate the FAT type as follows
-	// if clusterCount < 4085       = FAT12
-	// else if clusterCount < 65525 = FAT16
-	// else                         = FAT32
-	// for some reason you can create different FAT types disregarding
-	// cluster count when using mkfs.fat on linux
-	// that's why I tried another method to figure out FAT type checking root dir sector count
-	// I'm not sure if it is correct
-	info.DataSectors = info.TotalSectors - (uint32(bpb.ReservedSectorCount) +
-		uint32(bpb.NFATs)*info.FATSectors +
-		uint32(info.RootDirSectors))
-
-	info.ClusterCount = info.DataSectors / uint32(bpb.SectorPerCluster)
-
-	// calculate cluster size in bytes
-	info.ClusterSize = uint32(bpb.SectorPerCluster) * uint32(bpb.BytesPerSector)
-
-	// set FAT type by cluster count or set a warning if the type mismatch
-	switch ccnt := info.ClusterCount; {
-	case ccnt < 4085:
-		if info.Type == 0 {
-			info.Type = FAT12
-		} else {
-			info.Warning = fmt.Sprintf(
-				"according to my calculations FAT type is %d but the cluster count point to FAT12",
-				info.Type,
-			)
-		}
-	case ccnt < 65525:
-		if info.Type == 0 {
-			info.Type = FAT16
-		} else {
-			info.Warning = fmt.Sprintf(
-				"according to my calculations FAT type is %d but the cluster count point to FAT16",
-				info.Type,
-			)
-		}
-	default:
-		if info.Type == 0 || info.Type == FAT32 {
-			info.Type = FAT32
-		} else {
-			info.Warning = fmt.Sprintf(
-				"according to my calculations FAT type is %d but the cluster count point to FAT32",
-				info.Type,
-			)
-		}
-	}
+	return 0
+}
 
-	// calculate offsets
-	switch info.Type {
-	case FAT12, FAT16:
-		info.RootDirOffset = (uint32(bpb.ReservedSectorCount) + info.FATNumber*info.FATSectors) *
-			uint32(bpb.BytesPerSector)
-	case FAT32:
-		info.RootDirOffset = (uint32(bpb.ReservedSectorCount) +
-			info.FATNumber*info.FATSectors +
-			(ext32.RootCluster-2)*uint32(bpb.SectorPerCluster)) * uint32(bpb.BytesPerSector)
-	}
+func get(args []string) int {
+	fset := flag.NewFlagSet("get", flag.ExitOnError)
+	recursive := fset.Bool("r", false, "copy directories and everything inside them")
+	hidden := fset.Bool("hidden", false, "copy hidden files and directories too")
+	system := fset.Bool("system", false, "copy system files and directories too")
+	jobs := fset.Int("j", 4, "files copied at the same time")
 
-	info.FATOffset = uint32(bpb.ReservedSectorCount) * uint32(bpb.BytesPerSector)
-	info.DataOffset = (uint32(bpb.ReservedSectorCount) +
-		info.FATNumber*info.FATSectors + info.RootDirSectors) *
-		uint32(bpb.BytesPerSector)
+	v, rest := subcommand(fset, commands["get"].usage, args, 2, 2)
+	defer v.Close()
 
-	return
-}
+	err := v.ExtractTree(rest[0], rest[1], fat.ExtractOptions{
+		Recursive: *recursive,
+		Hidden:    *hidden,
+		System:    *system,
+		Parallel:  *jobs,
+	})
+	checkerr(rest[0], err)
 
-func findFile(name string, entries []EntryInfo) (ok bool, file EntryInfo) {
-	for _, v := range entries {
-		if name == v.LongName || name == v.ShortName {
-			file = v
-			break
-		}
-	}
-	return file != (EntryInfo{}), file
+	return 0
 }
 
-func walk(
-	file *os.File,
-	bpb BPB,
-	info FATInfo,
-	src []EntryInfo,
-	dst string,
-) (content []EntryInfo, err error) {
-	ok, entry := findFile(dst, src)
-	if !ok {
-		return nil, errors.New("entry not found")
-	}
+func put(args []string) int {
+	fset := flag.NewFlagSet("put", flag.ExitOnError)
+	recursive := fset.Bool("r", false, "copy directories and everything inside them")
+	follow := fset.Bool("L", false, "follow symlinks instead of skipping them")
+	var include, exclude globs
+	fset.Var(&include, "include", "copy only the files matching glob, can be repeated")
+	fset.Var(&exclude, "exclude", "skip files and directories matching glob, can be repeated")
 
-	if entry.Attr&0x10 == 0 {
-		return []EntryInfo{entry}, nil
-	}
+	v, rest := subcommand(fset, commands["put"].usage, args, 2, 2)
+	defer v.Close()
 
-	offset := info.DataOffset +
-		(entry.Location-2)*uint32(bpb.SectorPerCluster)*uint32(bpb.BytesPerSector)
+	err := v.ImportTree(rest[0], rest[1], fat.ImportOptions{
+		Recursive:      *recursive,
+		Include:        include,
+		Exclude:        exclude,
+		FollowSymlinks: *follow,
+	})
+	checkerr(rest[0], err)
 
-	return readDir(file, int64(offset))
+	return 0
 }
 
-func readDir(file *os.File, offset int64) (entries []EntryInfo, err error) {
-	if _, err = file.Seek(offset, io.SeekStart); err != nil {
-		return
-	}
+// globs is a flag that can be given many times
+type globs []string
 
-	var lastLongFilename uint8
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
 
-	longFilenames := make(map[uint8][][]byte)
+func (g *globs) Set(s string) error {
+	*g = append(*g, s)
+	return nil
+}
 
-OUT:
-	for {
-		// seek forward to only read attribute
-		// maybe this can be done in a nicer way
-		if _, err = file.Seek(11, io.SeekCurrent); err != nil {
-			return
-		}
+func rm(args []string) int {
+	fset := flag.NewFlagSet("rm", flag.ExitOnError)
+	recursive := fset.Bool("r", false, "remove directories and everything inside them")
+	secure := fset.Bool("secure", false, "zero the freed clusters")
 
-		var attr uint8
-		if err = binary.Read(file, binary.LittleEndian, &attr); err != nil {
-			return
-		}
+	v, paths := subcommand(fset, commands["rm"].usage, args, 1, -1)
+	defer v.Close()
 
-		// move cursor back and read the whole entry
-		if _, err = file.Seek(-12, io.SeekCurrent); err != nil {
-			return
-		}
-
-		var entryInfo EntryInfo
-
-		switch attr {
-		case AttrEnd: // end of entries
-			break OUT
-		case AttrVolID | AttrArchive, AttrVolID: // volume id
-			var entry DirEntry
-			if err = binary.Read(file, binary.LittleEndian, &entry); err != nil {
-				return
-			}
-
-			var name []byte
-			for _, v := range entry.Name {
-				name = append(name, v)
-			}
-
-			creationTime := fatTimeToTime(entry.CDate, entry.CTime)
-			writeTime := fatTimeToTime(entry.WDate, entry.WTime)
-
-			entryInfo = EntryInfo{
-				ShortName: string(name),
-				Attr:      entry.Attr,
-				Crt:       creationTime,
-				Mod:       writeTime,
-			}
-
-		case AttrLongName: // long filename
-			var entry DirEntryLong
-
-			if err = binary.Read(file, binary.LittleEndian, &entry); err != nil {
-				return
-			}
-
-			lf, ok := longFilenames[entry.Checksum]
-
-			var part []byte
-
-			for _, v := range entry.Name1 {
-				if v == 0xff {
-					break
-				}
-				part = append(part, v)
-			}
-
-			for _, v := range entry.Name2 {
-				if v == 0xff {
-					break
-				}
-				part = append(part, v)
-			}
-
-			for _, v := range entry.Name3 {
-				if v == 0xff {
-					break
-				}
-				part = append(part, v)
-			}
-
-			if !ok {
-				longFilenames[entry.Checksum] = [][]byte{part}
-			} else {
-				lf = append(lf, part)
-				longFilenames[entry.Checksum] = lf
-			}
-
-			if entry.Ordinal&0x3f == 1 {
-				lastLongFilename = entry.Checksum
-			}
-
-			continue
-
-		default: // short filename
-			var short DirEntry
-			if err = binary.Read(file, binary.LittleEndian, &short); err != nil {
-				return
-			}
-
-			var shortName []byte
-			for _, v := range short.Name {
-				shortName = append(shortName, v)
-			}
-
-			writeTime := fatTimeToTime(short.WDate, short.WTime)
-
-			entryInfo = EntryInfo{
-				ShortName: string(shortName),
-				Attr:      short.Attr,
-				Location:  uint32(short.FirstClusterHI)<<16 + uint32(short.FirstClusterLO),
-				Size:      short.FileSize,
-				Crt:       writeTime,
-				Mod:       writeTime,
-			}
-
-			// if there's a checksum saved add long filename to the entry
-			if lastLongFilename != 0 {
-				longName := buildLongFilename(longFilenames[lastLongFilename])
-				entryInfo.LongName = string(longName)
-				lastLongFilename = 0
-			}
+	status := 0
+	for _, path := range paths {
+		err := v.Remove(path, fat.RemoveOptions{Recursive: *recursive, Secure: *secure})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 1
 		}
-
-		entries = append(entries, entryInfo)
 	}
 
-	return
+	return status
 }
 
-// splitPath split the path and returns a slice with all the names
-func splitPath(path string) (elements []string) {
-	var dir, file string
-	dir = path
-
-	for {
-		dir = filepath.Clean(dir)
-		dir, file = filepath.Split(dir)
+func mkdir(args []string) int {
+	fset := flag.NewFlagSet("mkdir", flag.ExitOnError)
+	parents := fset.Bool("p", false, "create missing parent directories, existing ones are not an error")
 
-		if file == "" {
-			break
-		}
-
-		elements = append(elements, file)
+	v, paths := subcommand(fset, commands["mkdir"].usage, args, 1, -1)
+	defer v.Close()
 
-		if dir == "" {
-			break
+	status := 0
+	for _, path := range paths {
+		if err := v.Mkdir(path, *parents); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 1
 		}
 	}
-	slices.Reverse(elements)
-	return
+
+	return status
 }
 
-// buildLongFilename process parts of a long filename and converts it into a byte slice
-func buildLongFilename(src [][]byte) (lf []byte) {
-	for i := len(src) - 1; i >= 0; i-- {
-		part := src[i]
-		limit := len(part)
+func rmdir(args []string) int {
+	fset := flag.NewFlagSet("rmdir", flag.ExitOnError)
 
-		if i == 0 {
-			limit -= 2
-		}
+	v, paths := subcommand(fset, commands["rmdir"].usage, args, 1, -1)
+	defer v.Close()
 
-		for j := 0; j < limit; j += 2 {
-			lf = append(lf, part[j])
+	status := 0
+	for _, path := range paths {
+		if err := v.Rmdir(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 1
 		}
 	}
 
-	return
+	return status
 }
 
-// doILookFAT checks if it's an actual FAT filesystem
-func doILookFAT(bpb BPB) bool {
-	switch bpb.JumpBoot[0] {
-	case 0xEB, 0xE9:
-		return true
-	}
+func mv(args []string) int {
+	fset := flag.NewFlagSet("mv", flag.ExitOnError)
+	overwrite := fset.Bool("f", false, "replace the target if it exists")
 
-	return false
-}
+	v, paths := subcommand(fset, commands["mv"].usage, args, 2, 2)
+	defer v.Close()
 
-func checkerr(msg string, err error) {
-	if err != nil {
-		if msg == "" {
-			fmt.Fprintln(os.Stderr, err.Error())
-		} else {
-			fmt.Fprintf(os.Stderr, "%s: %s\n", msg, err.Error())
-		}
+	checkerr("", v.Rename(paths[0], paths[1], *overwrite))
 
-		os.Exit(-1)
-	}
+	return 0
 }
 
-func mkentry(t uint8) (eof uint32, fatEntry []byte) {
-	switch t {
-	case FAT12:
-		fatEntry = make([]byte, 2)
-		eof = 0xfff
-	case FAT16:
-		fatEntry = make([]byte, 2)
-		eof = 0xffff
-	case FAT32:
-		fatEntry = make([]byte, 4)
-		eof = 0xfffffff
-	}
-	return
-}
-
-func locFromEntry(t uint8, fatEntry []byte) uint32 {
-	switch t {
-	case FAT12, FAT16:
-		return uint32(binary.LittleEndian.Uint16(fatEntry))
-	case FAT32:
-		return binary.LittleEndian.Uint32(fatEntry)
-	}
-	panic("not a correct fat type")
-}
+func truncate(args []string) int {
+	fset := flag.NewFlagSet("truncate", flag.ExitOnError)
 
-func locToEntry(t uint8, location uint32) (entry []byte) {
-	switch t {
-	case FAT12, FAT16:
-		entry = make([]byte, 2)
-		binary.LittleEndian.PutUint16(entry, uint16(location))
-	case FAT32:
-		entry = make([]byte, 4)
-		binary.LittleEndian.PutUint32(entry, location)
-	}
-	return
-}
+	v, rest := subcommand(fset, commands["truncate"].usage, args, 2, 2)
+	defer v.Close()
 
-func putLocToEntry(t uint8, entry []byte, location uint32) {
-	switch t {
-	case FAT12, FAT16:
-		binary.LittleEndian.PutUint16(entry, uint16(location))
-	case FAT32:
-		binary.LittleEndian.PutUint32(entry, location)
+	size, err := parseSize(rest[1])
+	checkerr("size", err)
+	if size < 0 || size > math.MaxUint32 {
+		checkerr("size", fmt.Errorf("%d out of range", size))
 	}
-}
 
-func getFileOffset(location uint32, bpb BPB, info FATInfo) uint32 {
-	// here we calculate the file offset inside the file region
-	// the first two clusters numbers are reserved
-	// so we substract them from the `Location` number
-	return info.DataOffset + (location-2)*uint32(bpb.SectorPerCluster)*uint32(bpb.BytesPerSector)
-}
+	checkerr("", v.Truncate(rest[0], uint32(size)))
 
-func getFATEntryOffset(location uint32, entryLen int, info FATInfo) int64 {
-	return int64(info.FATOffset) + int64(entryLen)*int64(location)
+	return 0
 }
 
-func findEmptyFAT(file *os.File, startLoc uint32, info FATInfo) (emptyLoc uint32, err error) {
-	// this function finds the next empty location inside the FAT region
+func parts(args []string) int {
+	fset := flag.NewFlagSet("parts", flag.ExitOnError)
+	path, _ := parseArgs(fset, commands["parts"].usage, args, 0, 0)
 
-	_, fatEntry := mkentry(info.Type)
-	entrySize := int64(len(fatEntry))
-	max := int64(info.FATOffset + info.FATSectors*info.FATNumber*info.SectorSize)
-
-	// move to initial offset
-	initial := int64(info.FATOffset) + int64(startLoc)*entrySize
-	if _, err = file.Seek(initial, io.SeekStart); err != nil {
-		return
-	}
+	file, err := os.Open(path)
+	checkerr("", err)
+	defer file.Close()
 
-	for i := startLoc; int64(info.FATOffset)+int64(i)*entrySize < max; i++ {
-		if err = binary.Read(file, binary.LittleEndian, fatEntry); err != nil {
-			return
-		}
+	partitions, err := fat.Partitions(file)
+	checkerr("", err)
 
-		if locFromEntry(info.Type, fatEntry) == 0 {
-			return i, nil
+	for _, p := range partitions {
+		fmt.Printf("%d: %s type %s (%s) offset %d size %d", p.Index, p.Scheme, p.Type, p.TypeName(), p.Offset, p.Size)
+		if p.Scheme == "gpt" {
+			fmt.Printf(" name %q guid %s", p.Name, p.GUID)
 		}
+		fmt.Println()
 	}
 
-	return 0, errors.New("no more empty entries left")
+	return 0
 }
 
-func addFile(file *os.File, info FATInfo, fileEntry EntryInfo) (err error) {
-	if _, err = file.Seek(int64(info.RootDirOffset), io.SeekStart); err != nil {
-		return err
+// parseSize parses a size in bytes with an optional K, M or G suffix
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
 	}
 
-	fmt.Println("========================")
+	mult := int64(1)
 
-	for {
-		var entry DirEntry
-		if err = binary.Read(file, binary.LittleEndian, &entry); err != nil {
-			return err
-		}
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
 
-		if entry.Attr != 0x0 {
-			continue
-		}
-		if _, err = file.Seek(-32, io.SeekCurrent); err != nil {
-			return err
-		}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
 
-		// copy short name to dir entry
-		entry.Attr = fileEntry.Attr
-		for i, v := range []byte(fileEntry.ShortName) {
-			if i >= 11 {
-				break
-			}
-			entry.Name[i] = v
-		}
+	return n * mult, nil
+}
 
-		// write date/time
-		entry.WDate, entry.WTime = timeToFatTime(time.Now().UTC())
+// wFile writes stdin into the file name as mode says. A zero mod leaves the
+// file modified now
+func wFile(v *fat.Volume, name, mode string, mod time.Time) (err error) {
+	switch mode {
+	case "create":
+		return v.WriteFileTime(name, os.Stdin, mod)
+	case "overwrite":
+		err = v.OverwriteFile(name, os.Stdin)
+	case "append":
+		err = v.AppendFile(name, os.Stdin)
+	default:
+		return fmt.Errorf("unknown write mode %q", mode)
+	}
 
-		// creation date/time
-		entry.CDate, entry.CTime = entry.WDate, entry.WTime
+	if err != nil || mod.IsZero() {
+		return
+	}
 
-		// file size and first cluster
-		entry.FileSize = fileEntry.Size
-		entry.FirstClusterLO = uint16(fileEntry.Location)
+	// existing files are marked as modified now, the time given replaces it
+	return v.Chtimes(name, time.Time{}, mod)
+}
 
-		longEntries, err := convNameLong(fileEntry.LongName, fileEntry.ShortName)
-		if err != nil {
-			return err
-		}
+func pFAT(v *fat.Volume) (err error) {
+	n := v.FATEntries()
 
-		for _, e := range longEntries {
-			if err = binary.Write(file, binary.LittleEndian, e); err != nil {
-				return err
-			}
-		}
-
-		if err = binary.Write(file, binary.LittleEndian, entry); err != nil {
+	for i := uint32(0); i < n; i++ {
+		loc, err := v.FATEntry(i)
+		if err != nil {
 			return err
 		}
 
-		fmt.Println(longEntries)
-		fmt.Println(entry)
-
-		break
+		fmt.Printf("(%d) -> %v\n", i, loc)
 	}
 
-	fmt.Println("========================")
-
 	return
 }
 
-var validChars = map[byte]struct{}{
-	35:  {},
-	36:  {},
-	37:  {},
-	38:  {},
-	39:  {},
-	40:  {},
-	41:  {},
-	43:  {},
-	44:  {},
-	45:  {},
-	46:  {},
-	48:  {},
-	49:  {},
-	50:  {},
-	51:  {},
-	52:  {},
-	53:  {},
-	54:  {},
-	55:  {},
-	56:  {},
-	57:  {},
-	59:  {},
-	61:  {},
-	64:  {},
-	65:  {},
-	66:  {},
-	67:  {},
-	68:  {},
-	69:  {},
-	70:  {},
-	71:  {},
-	72:  {},
-	73:  {},
-	74:  {},
-	75:  {},
-	76:  {},
-	77:  {},
-	78:  {},
-	79:  {},
-	80:  {},
-	81:  {},
-	82:  {},
-	83:  {},
-	84:  {},
-	85:  {},
-	86:  {},
-	87:  {},
-	88:  {},
-	89:  {},
-	90:  {},
-	91:  {},
-	93:  {},
-	94:  {},
-	95:  {},
-	96:  {},
-	97:  {},
-	98:  {},
-	99:  {},
-	100: {},
-	101: {},
-	102: {},
-	103: {},
-	104: {},
-	105: {},
-	106: {},
-	107: {},
-	108: {},
-	109: {},
-	110: {},
-	111: {},
-	112: {},
-	113: {},
-	114: {},
-	115: {},
-	116: {},
-	117: {},
-	118: {},
-	119: {},
-	120: {},
-	121: {},
-	122: {},
-	123: {},
-	125: {},
-	126: {},
+func pFile(v *fat.Volume, path string) error {
+	return v.Extract(path, os.Stdout)
 }
 
-func convNameShort(name string) (short []byte, err error) {
-	short = make([]byte, 11)
-	b := []byte(name)
-
-	if len(b) == 0 {
-		return short, errors.New("name should at least have one character")
-	}
+func pReserved(v *fat.Volume) {
+	fmt.Printf("reserved: %+v\n", v.BPB)
 
-	switch b[0] {
-	case 0x20:
-		return short, errors.New("name cannot start with '.'")
+	switch v.Info.Type {
+	case fat.FAT12, fat.FAT16:
+		fmt.Printf("ext12/16: %+v\n", v.Ext16)
+	case fat.FAT32:
+		fmt.Printf("ext32: %+v\n", v.Ext32)
 	}
+}
 
-	splitted := strings.SplitN(name, ".", 2)
-
-	namePart := make([]byte, 8)
-	parted(splitted[0], namePart)
-
-	extPart := make([]byte, 3)
-
-	if len(splitted) == 1 {
-		parted("", extPart)
-	} else {
-		parted(splitted[1], extPart)
+func pRoot(v *fat.Volume, root []fat.EntryInfo) {
+	if label, err := v.Label(); err == nil && label != "" {
+		fmt.Printf("volume label: %s\n", label)
 	}
 
-	for i, v := range namePart {
-		short[i] = v
-	}
-	for i, v := range extPart {
-		short[i+8] = v
+	fmt.Println("files in root dir:")
+	for _, v := range root {
+		fmt.Printf("%+v\n", v)
 	}
-
-	return short, nil
 }
 
-func parted(og string, part []byte) {
-	for i, v := range []byte(strings.ToUpper(og)) {
-		if i == len(part) {
-			break
-		}
-		part[i] = v
-	}
-	for i, v := range part {
-		if v == 0x0 {
-			part[i] = 0x20
-		}
+func pType(info fat.FATInfo) {
+	switch info.Type {
+	case fat.FAT12:
+		fmt.Println("FAT12")
+	case fat.FAT16:
+		fmt.Println("FAT16")
+	case fat.FAT32:
+		fmt.Println("FAT32")
 	}
 }
 
-func convNameLong(name, shortName string) (entries []DirEntryLong, err error) {
-	chksm, err := checksum([]byte(shortName))
-	if err != nil {
-		return []DirEntryLong{}, err
-	}
-
-	const chunkSize = 13
-
-	nameBytes := []byte(name)
-	nparts := len(nameBytes) / chunkSize
-
-	entries = make([]DirEntryLong, nparts)
-
-	for i := 0; i < nparts; i++ {
-		lower := i * chunkSize
-		upper := lower + chunkSize
-
-		j := nparts - i - 1 // reverse index
-
-		longNameInsert(&entries[j], nameBytes[lower:upper])
-
-		entries[j].Attr = AttrLongName
-		entries[j].Checksum = chksm
-		entries[j].Ordinal = HexByte(i + 1)
-	}
-
-	if nparts != 0 && nparts%chunkSize == 0 {
-		entries[0].Ordinal = entries[0].Ordinal | 0x40
-		return entries, nil
-	}
-
-	lastEntry := DirEntryLong{
-		Attr:     AttrLongName,
-		Ordinal:  HexByte(nparts+1) | 0x40,
-		Checksum: chksm,
-	}
-
-	longNameInsert(&lastEntry, nameBytes[nparts*chunkSize:])
-
-	entries = append([]DirEntryLong{lastEntry}, entries...)
+func pInfo(v *fat.Volume) {
+	info := v.Info
 
-	return entries, nil
-}
+	fmt.Printf(`FAT Quantity: %d
+FAT Region Sectors: %d
+FAT Region offset: 0x%x
+Root Region Sectors: %d
+Root Region offset: 0x%x
+Data Region Sectors: %d
+Data Region offset: 0x%x
+Total Sectors: %d
+Cluster Count: %d
+Cluster Size: %d
+Sector Size: %d
+`,
+		info.FATNumber,
+		info.FATSectors,
+		info.FATOffset,
+		info.RootDirSectors,
+		info.RootDirOffset,
+		info.DataSectors,
+		info.DataOffset,
+		info.TotalSectors,
+		info.ClusterCount,
+		info.ClusterSize,
+		info.SectorSize,
+	)
 
-func longNameInsert(entry *DirEntryLong, part []byte) {
-	for i, c := range part {
-		switch {
-		case i >= 0 && i <= 4:
-			entry.Name1[i*2] = c
-		case i >= 5 && i <= 10:
-			entry.Name2[(i-5)*2] = c
-		case i >= 11 && i <= 12:
-			entry.Name3[(i-11)*2] = c
+	if info.Type == fat.FAT32 {
+		if v.FSInfo.Valid() {
+			fmt.Printf("FSInfo Free Count: %s\nFSInfo Next Free: %s\n",
+				fsInfoField(v.FSInfo.FreeCount), fsInfoField(v.FSInfo.NextFree))
+		} else {
+			fmt.Println("FSInfo: invalid signature")
 		}
 	}
-}
 
-func writeAt(r io.WriteSeeker, offset int64, data any) (err error) {
-	if _, err = r.Seek(offset, io.SeekStart); err != nil {
-		return
-	}
-	if err = binary.Write(r, binary.LittleEndian, data); err != nil {
-		return
+	if info.Warning != "" {
+		fmt.Printf("-----------------------------------\nWarn: %s\n", info.Warning)
 	}
-	return
 }
 
-func fatTimeToTime(d, t uint16) time.Time {
-	year, month, day := (d>>0x9)+1980, d>>0x5&0xf, d&0x1f
-	hours, minutes, seconds := t>>0xb, t>>0x5&0x3f, d&0x1f
-
-	return time.Date(
-		int(year),
-		time.Month(month),
-		int(day),
-		int(hours),
-		int(minutes),
-		int(seconds),
-		0,
-		time.UTC,
-	)
-}
-
-func timeToFatTime(t time.Time) (date, time uint16) {
-	year, month, day := t.Date()
-	hours, minutes, seconds := t.Hour(), t.Minute(), t.Second()
-
-	date = uint16(year-1980)<<0x9 | uint16(month)<<0x5 | uint16(day)
-	time = uint16(hours)<<0xb | uint16(minutes)<<0x5 | uint16(seconds/2)
-
-	return date, time
+func fsInfoField(n uint32) string {
+	if n == fat.FSInfoUnknown {
+		return "unknown"
+	}
+	return fmt.Sprint(n)
 }
 
-func checksum(shortName []byte) (sum uint8, err error) {
-	if len(shortName) != 11 {
-		return 0, errors.New("short name too long")
-	}
+func checkerr(msg string, err error) {
+	if err != nil {
+		if msg == "" {
+			fmt.Fprintln(os.Stderr, err.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", msg, err.Error())
+		}
 
-	for _, c := range shortName {
-		sum = 0x80*(sum&1) + (sum >> 1) + c
+		os.Exit(-1)
 	}
-
-	return sum, nil
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/argot42/lookfat/fat"
+)
+
+func TestFsckStatus(t *testing.T) {
+	const size = 16 << 20
+
+	path := filepath.Join(t.TempDir(), "image")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Truncate(size); err == nil {
+		err = fat.Format(f, size, fat.FormatOptions{})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := fat.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a byte of the second FAT copy differs from the first one
+	offset := int64(v.Info.FATOffset) + int64(v.Info.FATSectors)*int64(v.Info.SectorSize) + 100
+	if _, err = f.WriteAt([]byte{0xff}, offset); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{path}, 4},
+		{[]string{"-repair", path}, 1},
+		{[]string{path}, 0},
+	} {
+		if got := fsck(c.args); got != c.want {
+			t.Fatalf("fsck %q exited with %d, want %d", c.args, got, c.want)
+		}
+	}
+}